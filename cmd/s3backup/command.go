@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/lock"
+	"github.com/studio1767/s3backup/internal/manifest"
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/progress"
+	"github.com/studio1767/s3backup/internal/report"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// backupCommand runs the source's command and streams its output into the bucket
+// as a single file in the manifest. The manifest isn't uploaded if the source lock
+// has been lost.
+func backupCommand(client s3io.Client, job *job.Job, idx int, src_lock *lock.Lock, tracker *progress.Tracker, rpt *report.Source, compress bool) error {
+	source := job.Sources[idx]
+	if source.Name == "" {
+		return fmt.Errorf("command source has no name: %s", source.Label)
+	}
+
+	// find the entry in the previous manifest
+	mreader, mkey, err := manifest.Download(client, job.Name, source.Label)
+
+	var nomanifest *manifest.ErrNoSuchManifest
+	if err != nil && errors.As(err, &nomanifest) == false {
+		return err
+	}
+
+	var previous *ops.EntryInfo
+	if mreader != nil {
+		for ei := range ops.NewManifestScanner(context.Background(), mreader) {
+			if ei.RelPath == source.Name {
+				previous = ei
+			}
+		}
+		mreader.Close()
+		os.Remove(mreader.Name())
+
+		tracker.Printf("Processing %s/%s - %s\n", job.Name, source.Label, mkey)
+	} else {
+		tracker.Printf("Processing %s/%s\n", job.Name, source.Label)
+	}
+
+	tracker.Start(fmt.Sprintf("%s/%s", job.Name, source.Label))
+	defer tracker.Stop()
+
+	if previous != nil {
+		tracker.SetEstimate(1, previous.RawSize)
+	}
+
+	// start the command
+	cmd := exec.Command("/bin/sh", "-c", source.Command)
+	cmd.Dir = source.Path
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"S3BU_JOB="+job.Name,
+		"S3BU_LABEL="+source.Label,
+		"S3BU_PATH="+source.Path,
+	)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+
+	// stream the output into the bucket
+	info := &ops.EntryInfo{
+		Status:  ops.StatusNew,
+		RelPath: source.Name,
+		ModTime: time.Now().Unix(),
+		Mode:    0600,
+		Action:  ops.NoAction,
+	}
+
+	ops.UploadStream(client, stdout, info, compress, func(uerr error) error {
+		if uerr != nil {
+			cmd.Process.Kill()
+		}
+		err := cmd.Wait()
+		if err != nil {
+			return fmt.Errorf("command failed: %w", err)
+		}
+		return nil
+	})
+
+	// compare with the previous backup
+	if previous != nil && info.Action != ops.Failed {
+		info.Status = ops.StatusModified
+		if previous.Hash == info.Hash {
+			info.Status = ops.StatusOk
+			info.ModTime = previous.ModTime
+		}
+	}
+
+	tracker.Add(info.RawSize, info.RawSize, info.UploadedSize)
+	rpt.Add(info, info.UploadedSize)
+
+	switch info.Action {
+	case ops.Uploaded:
+		tracker.Printf("- uploaded: %s (%d, %d)\n", info.RelPath, info.RawSize, info.UploadedSize)
+	case ops.NoAction:
+		tracker.Printf("-  present: %s (%d)\n", info.RelPath, info.RawSize)
+	case ops.Failed:
+		tracker.Printf("-   failed: %s: %s\n", info.RelPath, info.ActionMessage)
+	}
+
+	tracker.Stop()
+
+	// write and upload the manifest if the content has changed
+	if info.Action != ops.Failed && info.Status != ops.StatusOk {
+		err := checkLock(src_lock)
+		if err != nil {
+			return err
+		}
+		key, err := uploadCommandManifest(client, job.Name, source.Label, info)
+		if err != nil {
+			return err
+		}
+		tracker.Printf("- uploaded: %s\n", key)
+		rpt.Manifest = key
+	}
+
+	tracker.Printf("\n")
+	tracker.Printf("Backup Summary\n")
+	tracker.Printf("       status: %s\n", info.Status)
+	tracker.Printf("       action: %s\n", info.Action)
+	tracker.Printf("         size: %s bytes\n", humanize.Comma(info.RawSize))
+	tracker.Printf("     uploaded: %s bytes\n", humanize.Comma(info.UploadedSize))
+	tracker.Printf("\n")
+
+	return nil
+}
+
+func uploadCommandManifest(client s3io.Client, jobname, label string, info *ops.EntryInfo) (string, error) {
+	stamp := time.Now().Unix()
+	tmpfile := filepath.Join(os.TempDir(), fmt.Sprintf("manifest-%05d.csv", stamp))
+	mwriter, err := os.Create(tmpfile)
+	if err != nil {
+		return "", err
+	}
+	defer mwriter.Close()
+	defer os.Remove(mwriter.Name())
+
+	// reuse the manifest writer so the format is the same
+	ch := make(chan *ops.EntryInfo, 1)
+	ch <- info
+	close(ch)
+
+	for ei := range ops.NewManifestWriter(context.Background(), ch, mwriter) {
+		if ei.Action == ops.Failed {
+			return "", fmt.Errorf("%s", ei.ActionMessage)
+		}
+	}
+
+	mwriter.Seek(0, io.SeekStart)
+
+	return manifest.Upload(client, mwriter, jobname, label)
+}
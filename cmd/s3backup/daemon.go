@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/metrics"
+	"github.com/studio1767/s3backup/internal/progress"
+	"github.com/studio1767/s3backup/internal/report"
+	"github.com/studio1767/s3backup/internal/s3io"
+	"github.com/studio1767/s3backup/internal/schedule"
+)
+
+// daemon runs the jobs on their schedules. A job is never run again while it
+// is still running; the scheduled run is skipped instead.
+type daemon struct {
+	client  s3io.Client
+	opts    *options
+	metrics *metrics.Metrics
+	reload  time.Duration
+
+	mu   sync.Mutex
+	wg   sync.WaitGroup
+	jobs []*jobState
+}
+
+type jobState struct {
+	name      string
+	key       string
+	job       *job.Job
+	schedules map[string]*schedule.Schedule
+	checked   time.Time
+
+	running bool
+	lastRun *report.Run
+	err     string
+}
+
+func runDaemon(client s3io.Client, jobnames []string, opts *options, mtx *metrics.Metrics, listen string, reload time.Duration) error {
+	if len(jobnames) == 0 {
+		return fmt.Errorf("no jobs to run")
+	}
+
+	d := daemon{
+		client:  client,
+		opts:    opts,
+		metrics: mtx,
+		reload:  reload,
+	}
+
+	// load the jobs; they must all be valid to start
+	for _, jobname := range jobnames {
+		st := jobState{
+			name: jobname,
+		}
+		err := d.load(&st)
+		if err != nil {
+			return err
+		}
+		d.jobs = append(d.jobs, &st)
+	}
+
+	// serve the status
+	if listen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/status", d.serveStatus)
+		if mtx != nil {
+			mux.Handle("/metrics", mtx)
+		}
+		go func() {
+			err := http.ListenAndServe(listen, mux)
+			if err != nil {
+				log.Fatalf("failed to serve status: %s", err)
+			}
+		}()
+	}
+
+	// stop cleanly on a signal, waiting for running jobs to finish
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Printf("daemon started with %d jobs", len(d.jobs))
+
+	for {
+		// wake at the start of the next minute
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+
+		select {
+		case sig := <-sigs:
+			log.Printf("received %s: waiting for running jobs", sig)
+			d.wg.Wait()
+			return nil
+		case <-time.After(next.Sub(now)):
+		}
+
+		d.tick(next)
+	}
+}
+
+// load downloads the latest job configuration and parses the schedules.
+func (d *daemon) load(st *jobState) error {
+	job, key, err := job.Download(d.client, st.name)
+	if err != nil {
+		return err
+	}
+
+	schedules, err := jobSchedules(job)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if len(schedules) == 0 {
+		log.Printf("%s: no schedules found", key)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st.job = job
+	st.key = key
+	st.schedules = schedules
+	st.checked = time.Now()
+	st.err = ""
+
+	return nil
+}
+
+// jobSchedules returns the schedule for each source, keyed by the label.
+// A source's schedule overrides the job's.
+func jobSchedules(job *job.Job) (map[string]*schedule.Schedule, error) {
+	schedules := make(map[string]*schedule.Schedule)
+
+	for _, source := range job.Sources {
+		expr := source.Schedule
+		if expr == "" {
+			expr = job.Schedule
+		}
+		if expr == "" {
+			continue
+		}
+
+		sched, err := schedule.Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", source.Label, err)
+		}
+		schedules[source.Label] = sched
+	}
+
+	return schedules, nil
+}
+
+func (d *daemon) tick(now time.Time) {
+	for _, st := range d.jobs {
+		d.checkReload(st)
+
+		d.mu.Lock()
+
+		// find the sources that are due
+		var labels map[string]bool
+		for label, sched := range st.schedules {
+			if sched.Matches(now) {
+				if labels == nil {
+					labels = make(map[string]bool)
+				}
+				labels[label] = true
+			}
+		}
+		if labels == nil {
+			d.mu.Unlock()
+			continue
+		}
+		if st.running {
+			log.Printf("%s: still running, skipping scheduled run", st.name)
+			d.mu.Unlock()
+			continue
+		}
+
+		st.running = true
+		job := st.job
+		d.mu.Unlock()
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+
+			log.Printf("%s: starting backup", job.Name)
+			tracker := progress.NewTracker(os.Stdout, false)
+			run := runJob(d.client, job, labels, tracker, d.opts, d.metrics, nil)
+			log.Printf("%s: backup finished: %s", job.Name, run.Result)
+
+			d.mu.Lock()
+			st.running = false
+			st.lastRun = run
+			d.mu.Unlock()
+		}()
+	}
+}
+
+// checkReload reloads the job if a new configuration has been uploaded. Errors are
+// logged and the current configuration is kept.
+func (d *daemon) checkReload(st *jobState) {
+	d.mu.Lock()
+	due := time.Since(st.checked) >= d.reload
+	current := st.key
+	d.mu.Unlock()
+
+	if !due {
+		return
+	}
+
+	key, _, err := d.client.LatestMatching(fmt.Sprintf("jobs/%s/", st.name))
+	if err == nil && key != current {
+		log.Printf("%s: loading new configuration %s", st.name, key)
+		err = d.load(st)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st.checked = time.Now()
+	if err != nil {
+		log.Printf("%s: failed to reload configuration: %s", st.name, err)
+		st.err = err.Error()
+	}
+}
+
+type jobStatus struct {
+	Job     string               `json:"job"`
+	Key     string               `json:"key"`
+	Running bool                 `json:"running"`
+	Next    map[string]time.Time `json:"next"`
+	LastRun *report.Run          `json:"last_run,omitempty"`
+	Error   string               `json:"error,omitempty"`
+}
+
+func (d *daemon) serveStatus(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+
+	now := time.Now()
+	var status []jobStatus
+	for _, st := range d.jobs {
+		js := jobStatus{
+			Job:     st.name,
+			Key:     st.key,
+			Running: st.running,
+			Next:    make(map[string]time.Time),
+			LastRun: st.lastRun,
+			Error:   st.err,
+		}
+		for label, sched := range st.schedules {
+			js.Next[label] = sched.Next(now)
+		}
+		status = append(status, js)
+	}
+
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(status)
+}
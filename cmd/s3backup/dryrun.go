@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/manifest"
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/progress"
+	"github.com/studio1767/s3backup/internal/report"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// dryRunSource compares the source with its previous manifest and reports what a
+// backup would upload, without writing anything to the bucket. With hashing, the
+// new and modified files are hashed so content repeated within the source is only
+// counted once; with exists, the bucket is also checked for the content so files
+// that are already there aren't counted.
+func dryRunSource(client s3io.Client, job *job.Job, idx int, tracker *progress.Tracker, rpt *report.Source, opts *options) error {
+	source := job.Sources[idx]
+
+	// commands are only run for real
+	if source.Command != "" {
+		tracker.Printf("Skipping %s/%s: command sources aren't run in a dry run\n", job.Name, source.Label)
+		return nil
+	}
+
+	err := checkSource(source.Path)
+	if err != nil {
+		return err
+	}
+
+	// download the manifest for the label
+	mreader, mkey, err := manifest.Download(client, job.Name, source.Label)
+
+	var nomanifest *manifest.ErrNoSuchManifest
+	if err != nil && errors.As(err, &nomanifest) == false {
+		return err
+	}
+	if mreader != nil {
+		defer mreader.Close()
+		defer os.Remove(mreader.Name())
+	}
+
+	if mreader == nil {
+		tracker.Printf("Dry run %s/%s\n", job.Name, source.Label)
+	} else {
+		tracker.Printf("Dry run %s/%s - %s\n", job.Name, source.Label, mkey)
+	}
+
+	tracker.Start(fmt.Sprintf("%s/%s", job.Name, source.Label))
+	defer tracker.Stop()
+
+	if mreader != nil {
+		files, bytes, err := estimateManifest(mreader)
+		if err != nil {
+			return err
+		}
+		tracker.SetEstimate(files, bytes)
+	}
+
+	// context to cancel the operation
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the same chain as a backup, up to the hashing
+	var mch <-chan *ops.EntryInfo
+	if mreader != nil {
+		mch = ops.NewManifestScanner(ctx, mreader)
+	}
+	ch := ops.NewSourceStream(ctx, ops.NewFsScanner(ctx, source.Path, job), job, mch)
+
+	hashing := opts.dryHash || opts.dryExists
+	if hashing {
+		ch = ops.NewHashGenerator(ctx, ch, source.Path)
+	}
+
+	// run the chain
+	total := 0
+	count_ok := 0
+	count_new := 0
+	count_modified := 0
+	count_notfound := 0
+	count_present := 0
+	count_failed := 0
+	count_upload := 0
+	var bytes_new int64 = 0
+	var bytes_modified int64 = 0
+	var bytes_upload int64 = 0
+
+	seen := make(map[string]bool)
+
+	for ei := range ch {
+		total++
+		rpt.Add(ei, 0)
+
+		if ei.Action == ops.Failed {
+			count_failed++
+			tracker.Printf("-   failed: %s: %s\n", ei.RelPath, ei.ActionMessage)
+			tracker.Add(ei.RawSize, 0, 0)
+			continue
+		}
+
+		switch ei.Status {
+		case ops.StatusOk:
+			count_ok++
+			tracker.Add(ei.RawSize, 0, 0)
+			continue
+
+		case ops.StatusNotFound:
+			count_notfound++
+			tracker.Printf("-  missing: %s (%s bytes)\n", ei.RelPath, humanize.Comma(ei.RawSize))
+			continue
+
+		case ops.StatusNew:
+			count_new++
+			bytes_new += ei.RawSize
+			tracker.Printf("-      new: %s (%s bytes)\n", ei.RelPath, humanize.Comma(ei.RawSize))
+
+		case ops.StatusModified:
+			count_modified++
+			bytes_modified += ei.RawSize
+			tracker.Printf("- modified: %s (%s bytes)\n", ei.RelPath, humanize.Comma(ei.RawSize))
+		}
+
+		var hashed int64
+		if hashing {
+			hashed = ei.RawSize
+		}
+		tracker.Add(ei.RawSize, hashed, 0)
+
+		// content that's already in the bucket, or already counted, isn't uploaded
+		if hashing {
+			if seen[ei.Hash] {
+				count_present++
+				continue
+			}
+			seen[ei.Hash] = true
+
+			if opts.dryExists {
+				key := fmt.Sprintf("data/%s/%s", ei.Hash[:4], ei.Hash)
+				if exists, _ := client.Exists(key); exists {
+					count_present++
+					if opts.verbose {
+						tracker.Printf("-  present: %s\n", ei.RelPath)
+					}
+					continue
+				}
+			}
+		}
+
+		count_upload++
+		bytes_upload += ei.RawSize
+	}
+
+	tracker.Stop()
+
+	rpt.EstimatedUpload = bytes_upload
+
+	tracker.Printf("\n")
+	tracker.Printf("Dry Run Summary\n")
+	tracker.Printf(" files:\n")
+	tracker.Printf("        total: %d\n", total)
+	tracker.Printf("   unmodified: %d\n", count_ok)
+	tracker.Printf("          new: %d (%s bytes)\n", count_new, humanize.Comma(bytes_new))
+	tracker.Printf("     modified: %d (%s bytes)\n", count_modified, humanize.Comma(bytes_modified))
+	tracker.Printf("    not found: %d\n", count_notfound)
+	tracker.Printf("       failed: %d\n", count_failed)
+	tracker.Printf(" estimated upload:\n")
+	tracker.Printf("        files: %d\n", count_upload)
+	tracker.Printf("        bytes: %s (%s) before compression\n", humanize.Comma(bytes_upload), humanize.Bytes(uint64(bytes_upload)))
+	if hashing {
+		tracker.Printf(" deduplicated: %d\n", count_present)
+	}
+	tracker.Printf("\n")
+
+	return nil
+}
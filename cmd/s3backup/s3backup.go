@@ -13,36 +13,103 @@ import (
 
 	humanize "github.com/dustin/go-humanize"
 
+	"github.com/studio1767/s3backup/internal/cache"
+	"github.com/studio1767/s3backup/internal/hooks"
+	"github.com/studio1767/s3backup/internal/index"
 	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/lock"
 	"github.com/studio1767/s3backup/internal/manifest"
+	"github.com/studio1767/s3backup/internal/metrics"
+	"github.com/studio1767/s3backup/internal/notify"
 	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/progress"
+	"github.com/studio1767/s3backup/internal/report"
 	"github.com/studio1767/s3backup/internal/s3io"
 )
 
 func main() {
 	// process the command line
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [-v] [-p aws-profile] [-s secrets-file] [-c] <bucket> <job> [<label>]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "Usage: %s [-v] [-P] [-e] [-n [-hash] [-exists]] [-json] [-report-file file] [-metrics-file file] [-upload-limit rate] [-download-limit rate] [-p aws-profile] [-s secrets-file] [-c] <bucket> <job> [<label>]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s -d [-l listen-address] [-reload interval] [options] <bucket> <job> [<job> ...]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s -w [-interval interval] [options] <bucket> <job> [<label>]\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
 
 	verbose := flag.Bool("v", false, "verbose reporting")
 	compress := flag.Bool("c", false, "compress data before backing up")
+	show_progress := flag.Bool("P", false, "show progress with totals, throughput and ETA")
+	prescan := flag.Bool("e", false, "estimate totals by pre-scanning the source instead of using the manifest")
 	profile := flag.String("p", "default", "aws profile for credentials and configuration")
 	secrets_file := flag.String("s", "default", "yaml file containing secret passphrases for metadata")
+	json_report := flag.Bool("json", false, "write a json report to stdout; other output goes to stderr")
+	report_file := flag.String("report-file", "", "write a json report to this file")
+	metrics_file := flag.String("metrics-file", "", "write prometheus metrics to this node_exporter textfile")
+	daemon := flag.Bool("d", false, "run as a daemon, backing up the jobs on their schedules")
+	listen := flag.String("l", "", "in daemon mode, address to serve /status and /metrics on, e.g. localhost:9310")
+	reload := flag.Duration("reload", 5*time.Minute, "in daemon mode, how often to check for new job configurations")
+	upload_limit := flag.String("upload-limit", "", "limit the upload rate, e.g. 2MB or 2MB@08:00-18:00; overrides the job")
+	download_limit := flag.String("download-limit", "", "limit the download rate; overrides the job")
+	watching := flag.Bool("w", false, "watch the sources and back up the changed files continuously")
+	interval := flag.Duration("interval", 5*time.Minute, "in watch mode, how often to back up the changed files")
+	var dry_run bool
+	flag.BoolVar(&dry_run, "n", false, "dry run: report what would be uploaded without changing the bucket")
+	flag.BoolVar(&dry_run, "dry-run", false, "the same as -n")
+	dry_hash := flag.Bool("hash", false, "in a dry run, hash the new and modified files to count repeated content once")
+	dry_exists := flag.Bool("exists", false, "in a dry run, check the bucket for the content of new and modified files; implies -hash")
+	cache_dir := flag.String("cache", "default", "directory to cache manifests and jobs in, default '~/.s3bu/cache'; 'none' to disable")
+	cache_size := flag.String("cache-size", "1GB", "the most space the cache can use")
+	index_dir := flag.String("index", "default", "directory to keep the index of uploaded content in, default '~/.s3bu/index'; 'none' to disable")
+	index_age := flag.Duration("index-age", index.DefaultMaxAge, "how often to refresh the index from a listing of the bucket")
+	lock_wait := flag.Duration("lock-wait", 0, "how long to wait for a lock held by another run")
+	no_lock := flag.Bool("no-lock", false, "don't lock the bucket and sources while backing up")
 	flag.Parse()
 
-	if flag.NArg() != 2 && flag.NArg() != 3 {
+	if *daemon && *watching {
+		fmt.Fprintf(os.Stderr, "Error: daemon and watch modes can't be used together\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if dry_run && (*daemon || *watching) {
+		fmt.Fprintf(os.Stderr, "Error: a dry run can't be used in daemon or watch mode\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if flag.NArg() < 2 {
 		fmt.Fprintf(os.Stderr, "Error: incorrect arguments provided\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	bucket := flag.Arg(0)
-	jobname := flag.Arg(1)
-	label := ""
-	if flag.NArg() == 3 {
-		label = flag.Arg(2)
+
+	var err error
+	opts := options{
+		bucket:      bucket,
+		compress:    *compress,
+		prescan:     *prescan,
+		verbose:     *verbose,
+		jsonReport:  *json_report,
+		reportFile:  *report_file,
+		metricsFile: *metrics_file,
+		dryRun:      dry_run,
+		dryHash:     *dry_hash,
+		dryExists:   *dry_exists,
+	}
+
+	if *upload_limit != "" {
+		opts.uploadLimit, err = s3io.ParseRateLimit(*upload_limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *download_limit != "" {
+		opts.downloadLimit, err = s3io.ParseRateLimit(*download_limit)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// create the s3 client
@@ -50,6 +117,50 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	client, err = cache.Wrap(client, *cache_dir, *cache_size)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err = index.Wrap(client, *profile, bucket, *index_dir, *index_age)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *no_lock == false {
+		opts.locker = lock.NewLocker(client, filepath.Base(os.Args[0]), lock.DefaultTTL, *lock_wait)
+	}
+
+	// the metrics are merged into the existing textfile
+	var mtx *metrics.Metrics
+	if opts.metricsFile != "" || *listen != "" {
+		mtx = metrics.New()
+	}
+	if opts.metricsFile != "" {
+		err := mtx.LoadTextfile(opts.metricsFile)
+		if err != nil {
+			log.Printf("failed to load metrics: %s", err)
+		}
+	}
+
+	// in daemon mode, all the arguments after the bucket are jobs
+	if *daemon {
+		err := runDaemon(client, flag.Args()[1:], &opts, mtx, *listen, *reload)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.NArg() != 2 && flag.NArg() != 3 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect arguments provided\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	jobname := flag.Arg(1)
+	var labels map[string]bool
+	if flag.NArg() == 3 {
+		labels = map[string]bool{flag.Arg(2): true}
+	}
 
 	// download the job
 	job, _, err := job.Download(client, jobname)
@@ -57,33 +168,310 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// backup the sources
+	// progress reporting; if the report is going to stdout, everything else goes to stderr
+	out := os.Stdout
+	if opts.jsonReport {
+		out = os.Stderr
+	}
+	tracker := progress.NewTracker(out, *show_progress)
+
+	if *watching {
+		err := runWatch(client, job, labels, tracker, &opts, mtx, *interval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	run := runJob(client, job, labels, tracker, &opts, mtx, nil)
+
+	os.Exit(run.ExitCode())
+}
+
+// options controls how jobs are backed up and reported.
+type options struct {
+	bucket      string
+	compress    bool
+	prescan     bool
+	verbose     bool
+	jsonReport  bool
+	reportFile  string
+	metricsFile string
+
+	// report what would be uploaded without uploading anything
+	dryRun    bool
+	dryHash   bool
+	dryExists bool
+
+	uploadLimit   *s3io.RateLimit
+	downloadLimit *s3io.RateLimit
+
+	// takes the locks on the bucket and sources; nil to run without them
+	locker *lock.Locker
+}
+
+// runJob backs up the job's sources that are in the labels, or all of them if labels
+// is nil, with the job hooks around them. Sources with an entry in changed only have
+// those paths scanned. Once complete, the reports, metrics and notifications are
+// written and sent.
+func runJob(client s3io.Client, job *job.Job, labels map[string]bool, tracker *progress.Tracker, opts *options, mtx *metrics.Metrics, changed map[string][]string) *report.Run {
+	run := report.NewRun(filepath.Base(os.Args[0]), opts.bucket, job.Name)
+
+	// in daemon mode jobs run at the same time, so each sets its rate limits,
+	//   storage classes and tags on its own client
+	client = client.Clone()
+	run.DryRun = opts.dryRun
+
+	// run the job pre-backup hook; a failure aborts the job. The hooks aren't
+	//   run, or notifications sent, for a dry run
+	jobenv := hooks.Env{Bucket: opts.bucket, Job: job.Name}
+
+	err := setLimits(client, job, opts)
+	if err == nil {
+		err = setUploadPolicies(client, job, "")
+	}
+	// the repository is locked shared, so maintenance can't run during the backup
+	var repo_lock *lock.Lock
+	if err == nil && opts.dryRun == false && opts.locker != nil {
+		repo_lock, err = opts.locker.Acquire(lock.RepoScope, lock.Shared)
+	}
+	if err == nil && opts.dryRun == false {
+		err = hooks.Run(job.PreBackup, hooks.PreBackup, jobenv)
+	}
+	if err != nil {
+		tracker.Printf("Error: %s\n", err)
+		run.Fail(err)
+	} else {
+		// backup the sources
+		err = backupSources(client, job, labels, tracker, run, opts, changed)
+		if err != nil {
+			run.Fail(err)
+		}
+
+		// and the job post-backup hook
+		if opts.dryRun == false {
+			err = hooks.Run(job.PostBackup, hooks.PostBackup, jobenv)
+			if err != nil {
+				tracker.Printf("Error: %s\n", err)
+				run.Fail(err)
+			}
+		}
+	}
+
+	if repo_lock != nil {
+		err := repo_lock.Release()
+		if err != nil {
+			tracker.Printf("Error: %s\n", err)
+			run.Fail(err)
+		}
+	}
+
+	// write out the reports
+	run.Finish()
+
+	if opts.reportFile != "" {
+		err := run.WriteFile(opts.reportFile)
+		if err != nil {
+			log.Printf("failed to write report: %s", err)
+		}
+	}
+	if opts.jsonReport {
+		err := run.Write(os.Stdout)
+		if err != nil {
+			log.Printf("failed to write report: %s", err)
+		}
+	}
+	if opts.dryRun {
+		return run
+	}
+
+	if mtx != nil {
+		mtx.Update(run)
+		if opts.metricsFile != "" {
+			err := mtx.WriteTextfile(opts.metricsFile)
+			if err != nil {
+				log.Printf("failed to write metrics: %s", err)
+			}
+		}
+	}
+
+	// send the notifications
+	for _, err := range notify.Send(job.Notifications, run) {
+		log.Printf("failed to notify: %s", err)
+	}
+
+	return run
+}
+
+// setLimits sets the client's bandwidth limits from the options, or the job if
+// they aren't set. The limits apply to all transfers by the job's client.
+func setLimits(client s3io.Client, job *job.Job, opts *options) error {
+	upload := opts.uploadLimit
+	if upload == nil && job.UploadLimit != "" {
+		limit, err := s3io.ParseRateLimit(job.UploadLimit)
+		if err != nil {
+			return err
+		}
+		upload = limit
+	}
+
+	download := opts.downloadLimit
+	if download == nil && job.DownloadLimit != "" {
+		limit, err := s3io.ParseRateLimit(job.DownloadLimit)
+		if err != nil {
+			return err
+		}
+		download = limit
+	}
+
+	client.SetUploadLimit(upload)
+	client.SetDownloadLimit(download)
+
+	return nil
+}
+
+// setUploadPolicies sets the storage classes and tags the client uploads the data
+// and manifests with for the source with the label.
+func setUploadPolicies(client s3io.Client, job *job.Job, label string) error {
+	data, metadata, err := job.UploadPolicies(label)
+	if err != nil {
+		return err
+	}
+
+	client.SetUploadPolicy("data/", data)
+	client.SetUploadPolicy("manifests/", metadata)
+
+	return nil
+}
+
+// backupSources backs up each of the job's sources in the labels, running the source
+// hooks around each one. An error is returned if a source hook aborts the job.
+func backupSources(client s3io.Client, job *job.Job, labels map[string]bool, tracker *progress.Tracker, run *report.Run, opts *options, changed map[string][]string) error {
 	for idx, source := range job.Sources {
-		fmt.Printf("--------------------------------------------------------------\n")
+		tracker.Printf("--------------------------------------------------------------\n")
 
-		if label != "" && label != source.Label {
-			fmt.Printf("Skipping %s/%s\n", job.Name, source.Label)
+		if labels != nil && labels[source.Label] == false {
+			tracker.Printf("Skipping %s/%s\n", job.Name, source.Label)
 			continue
 		}
 
-		fi, err := os.Stat(source.Path)
-		if err != nil {
-			fmt.Printf("Error: failed to stat source: %s: %s\n", source.Label, err)
+		rpt := report.NewSource(job.Name, source.Label, source.Path)
+		run.Add(rpt)
+
+		if opts.dryRun {
+			err := dryRunSource(client, job, idx, tracker, rpt, opts)
+			if err != nil {
+				tracker.Printf("Error: %s\n", err)
+			}
+			rpt.Finish(err)
 			continue
 		}
-		if fi.IsDir() == false {
-			fmt.Printf("Error: source is not a directory: %s\n", source.Path)
+
+		// only one run can back up the source at a time, or one manifest would
+		//   silently replace the other
+		var src_lock *lock.Lock
+		if opts.locker != nil {
+			var err error
+			src_lock, err = opts.locker.Acquire(lock.SourceScope(job.Name, source.Label), lock.Exclusive)
+			if err != nil {
+				tracker.Printf("Error: %s\n", err)
+				rpt.Finish(err)
+				continue
+			}
+		}
+
+		// the pre-backup hook either skips this source or aborts the job on failure
+		srcenv := hooks.Env{Bucket: opts.bucket, Job: job.Name, Label: source.Label, Path: source.Path}
+
+		abort, err := hooks.Aborts(source.PreBackup)
+		if err == nil {
+			err = hooks.Run(source.PreBackup, hooks.PreBackup, srcenv)
+		}
+		if err != nil {
+			tracker.Printf("Error: %s\n", err)
+			rpt.Finish(err)
+			releaseLock(src_lock, tracker)
+			if abort {
+				return fmt.Errorf("aborted after %s/%s: %w", job.Name, source.Label, err)
+			}
 			continue
 		}
 
-		err = backupSource(client, job, idx, *compress, *verbose)
+		// the storage classes and tags depend on the label
+		err = setUploadPolicies(client, job, source.Label)
+		if err == nil && source.Command != "" {
+			err = backupCommand(client, job, idx, src_lock, tracker, rpt, opts.compress)
+		} else if err == nil {
+			err = checkSource(source.Path)
+			if err == nil {
+				err = backupSource(client, job, idx, src_lock, tracker, rpt, changed[source.Label], opts.compress, opts.prescan, opts.verbose)
+			}
+		}
 		if err != nil {
-			fmt.Println(err)
+			tracker.Printf("Error: %s\n", err)
 		}
+
+		// always run the post-backup hook once the pre-backup hook has succeeded
+		herr := hooks.Run(source.PostBackup, hooks.PostBackup, srcenv)
+		if herr != nil {
+			tracker.Printf("Error: %s\n", herr)
+			if err == nil {
+				err = herr
+			}
+		}
+
+		// a lost lock means another run may have backed up the source too
+		lerr := releaseLock(src_lock, tracker)
+		if err == nil {
+			err = lerr
+		}
+
+		rpt.Finish(err)
+	}
+
+	return nil
+}
+
+// releaseLock releases the lock if there is one, reporting any error.
+func releaseLock(l *lock.Lock, tracker *progress.Tracker) error {
+	if l == nil {
+		return nil
+	}
+	err := l.Release()
+	if err != nil {
+		tracker.Printf("Error: %s\n", err)
+	}
+	return err
+}
+
+// checkLock returns the error from refreshing the lock, if there is one, so a run
+// that may no longer hold its lock doesn't replace another run's manifest.
+func checkLock(l *lock.Lock) error {
+	if l == nil {
+		return nil
+	}
+	err := l.Err()
+	if err != nil {
+		return fmt.Errorf("not uploading the manifest: %w", err)
+	}
+	return nil
+}
+
+func checkSource(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+	if fi.IsDir() == false {
+		return fmt.Errorf("source is not a directory: %s", path)
 	}
+	return nil
 }
 
-func backupSource(client s3io.Client, job *job.Job, idx int, compress, verbose bool) error {
+// backupSource backs up the source against its previous manifest. If changed is not
+// nil, only those paths are scanned and the rest are taken from the manifest. The
+// manifest isn't uploaded if the source lock has been lost.
+func backupSource(client s3io.Client, job *job.Job, idx int, src_lock *lock.Lock, tracker *progress.Tracker, rpt *report.Source, changed []string, compress, prescan, verbose bool) error {
 	source := job.Sources[idx]
 
 	// download the manifest for the label
@@ -99,9 +487,26 @@ func backupSource(client s3io.Client, job *job.Job, idx int, compress, verbose b
 	}
 
 	if mreader == nil {
-		fmt.Printf("Processing %s/%s\n", job.Name, source.Label)
+		tracker.Printf("Processing %s/%s\n", job.Name, source.Label)
 	} else {
-		fmt.Printf("Processing %s/%s - %s\n", job.Name, source.Label, mkey)
+		tracker.Printf("Processing %s/%s - %s\n", job.Name, source.Label, mkey)
+	}
+	if changed != nil && mreader != nil {
+		tracker.Printf("Scanning %d changed paths\n", len(changed))
+	}
+
+	// estimate the totals for the progress reporting
+	tracker.Start(fmt.Sprintf("%s/%s", job.Name, source.Label))
+	defer tracker.Stop()
+
+	if prescan {
+		tracker.SetEstimate(estimateSource(source.Path, job))
+	} else if mreader != nil {
+		files, bytes, err := estimateManifest(mreader)
+		if err != nil {
+			return err
+		}
+		tracker.SetEstimate(files, bytes)
 	}
 
 	// create the manifest file to write to
@@ -118,24 +523,26 @@ func backupSource(client s3io.Client, job *job.Job, idx int, compress, verbose b
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// build the file processing chain
-	ch := ops.NewFsScanner(ctx, source.Path, job)
+	// build the file processing chain; without a manifest to patch, it's a full scan
+	var ch <-chan *ops.EntryInfo
+	if changed != nil && mreader != nil {
+		preader, err := os.Open(mreader.Name())
+		if err != nil {
+			return err
+		}
+		defer preader.Close()
 
-	if len(job.IncludeExtensions) > 0 {
-		ch = ops.NewFileExtensionFilter(ctx, ch, job.IncludeExtensions, true)
-	}
-	if len(job.ExcludeExtensions) > 0 {
-		ch = ops.NewFileExtensionFilter(ctx, ch, job.ExcludeExtensions, false)
+		ch = ops.NewPatchScanner(ctx, source.Path, job, ops.NewManifestScanner(ctx, preader), changed)
+	} else {
+		ch = ops.NewFsScanner(ctx, source.Path, job)
 	}
 
-	// build the manifest processing chain
+	// filter the files and compare them with the previous manifest
+	var mch <-chan *ops.EntryInfo
 	if mreader != nil {
-		// create the manifest reader
-		mch := ops.NewManifestScanner(ctx, mreader)
-
-		// and combine the streams...
-		ch = ops.NewStreamComparer(ctx, ch, mch)
+		mch = ops.NewManifestScanner(ctx, mreader)
 	}
+	ch = ops.NewSourceStream(ctx, ch, job, mch)
 
 	// build the tail of the chain
 	ch = ops.NewHashGenerator(ctx, ch, source.Path)
@@ -154,6 +561,7 @@ func backupSource(client s3io.Client, job *job.Job, idx int, compress, verbose b
 	var bytes_uploaded int64 = 0
 	for ei := range ch {
 		total++
+		rpt.Add(ei, ei.UploadedSize)
 
 		switch ei.Status {
 		case ops.StatusOk:
@@ -176,44 +584,90 @@ func backupSource(client s3io.Client, job *job.Job, idx int, compress, verbose b
 			count_failed++
 		}
 
+		if ei.Status != ops.StatusNotFound {
+			var hashed int64
+			if ei.Status == ops.StatusNew || ei.Status == ops.StatusModified {
+				hashed = ei.RawSize
+			}
+			tracker.Add(ei.RawSize, hashed, ei.UploadedSize)
+		}
+
 		if ei.Status == ops.StatusNew || ei.Status == ops.StatusModified {
 			if ei.Action == ops.Uploaded {
-				fmt.Printf("- uploaded: %s (%d, %d)\n", ei.RelPath, ei.RawSize, ei.UploadedSize)
+				tracker.Printf("- uploaded: %s (%d, %d)\n", ei.RelPath, ei.RawSize, ei.UploadedSize)
 			} else if verbose && ei.Action == ops.NoAction {
-				fmt.Printf("-  present: %s (%d)\n", ei.RelPath, ei.RawSize)
+				tracker.Printf("-  present: %s (%d)\n", ei.RelPath, ei.RawSize)
 			}
 		}
 		if ei.Action == ops.Failed {
-			fmt.Printf("-   failed: %s\n", ei.RelPath)
+			tracker.Printf("-   failed: %s\n", ei.RelPath)
 		}
 		if verbose && ei.Status == ops.StatusNotFound {
-			fmt.Printf("-  missing: %s\n", ei.RelPath)
+			tracker.Printf("-  missing: %s\n", ei.RelPath)
 		}
 	}
 
+	tracker.Stop()
+
 	// upload the manifest
 	if count_new > 0 || count_modified > 0 {
+		err := checkLock(src_lock)
+		if err != nil {
+			return err
+		}
 		mwriter.Seek(0, io.SeekStart)
 		key, err := manifest.Upload(client, mwriter, job.Name, source.Label)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("- uploaded: %s\n", key)
+		tracker.Printf("- uploaded: %s\n", key)
+		rpt.Manifest = key
 	}
 
-	fmt.Println()
-	fmt.Printf("Backup Summary\n")
-	fmt.Printf(" files:\n")
-	fmt.Printf("        total: %d\n", total)
-	fmt.Printf("   unmodified: %d\n", count_ok)
-	fmt.Printf("          new: %d\n", count_new)
-	fmt.Printf("     modified: %d\n", count_modified)
-	fmt.Printf("    not found: %d\n", count_notfound)
-	fmt.Printf(" actions:\n")
-	fmt.Printf("    no action: %d\n", count_noaction)
-	fmt.Printf("     uploaded: %d (%s bytes)\n", count_uploaded, humanize.Comma(bytes_uploaded))
-	fmt.Printf("       failed: %d\n", count_failed)
-	fmt.Println()
+	tracker.Printf("\n")
+	tracker.Printf("Backup Summary\n")
+	tracker.Printf(" files:\n")
+	tracker.Printf("        total: %d\n", total)
+	tracker.Printf("   unmodified: %d\n", count_ok)
+	tracker.Printf("          new: %d\n", count_new)
+	tracker.Printf("     modified: %d\n", count_modified)
+	tracker.Printf("    not found: %d\n", count_notfound)
+	tracker.Printf(" actions:\n")
+	tracker.Printf("    no action: %d\n", count_noaction)
+	tracker.Printf("     uploaded: %d (%s bytes)\n", count_uploaded, humanize.Comma(bytes_uploaded))
+	tracker.Printf("       failed: %d\n", count_failed)
+	tracker.Printf("\n")
 
 	return nil
 }
+
+// estimateSource runs the scanner and filters over the source to count the
+// files and bytes that will be processed.
+func estimateSource(path string, job *job.Job) (int64, int64) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := ops.NewSourceStream(ctx, ops.NewFsScanner(ctx, path, job), job, nil)
+
+	var files, bytes int64
+	for ei := range ch {
+		files++
+		bytes += ei.RawSize
+	}
+
+	return files, bytes
+}
+
+// estimateManifest counts the files and bytes in the previous manifest and
+// rewinds it ready for the backup.
+func estimateManifest(mreader *os.File) (int64, int64, error) {
+	var files, bytes int64
+	for ei := range ops.NewManifestScanner(context.Background(), io.NopCloser(mreader)) {
+		files++
+		bytes += ei.RawSize
+	}
+
+	_, err := mreader.Seek(0, io.SeekStart)
+
+	return files, bytes, err
+}
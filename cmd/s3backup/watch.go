@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/metrics"
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/progress"
+	"github.com/studio1767/s3backup/internal/s3io"
+	"github.com/studio1767/s3backup/internal/watch"
+)
+
+// runWatch backs up the job's sources in full and then watches them, backing up
+// just the changed paths every interval. If a watcher loses events, its source
+// falls back to a full scan. Command sources are only run in the first backup.
+func runWatch(client s3io.Client, job *job.Job, labels map[string]bool, tracker *progress.Tracker, opts *options, mtx *metrics.Metrics, interval time.Duration) error {
+	// start watching before the first backup so no changes are missed
+	watchers := make(map[string]*watch.Watcher)
+	defer func() {
+		for _, w := range watchers {
+			w.Close()
+		}
+	}()
+
+	filter := ops.NewDirFilter(job)
+	for _, source := range job.Sources {
+		if labels != nil && labels[source.Label] == false {
+			continue
+		}
+		if source.Command != "" {
+			continue
+		}
+
+		w, err := watch.New(source.Path, filter.SkipDir)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", job.Name, source.Label, err)
+		}
+		watchers[source.Label] = w
+	}
+	if len(watchers) == 0 {
+		return fmt.Errorf("no sources to watch")
+	}
+
+	// stop cleanly on a signal, after any running backup
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Printf("%s: watching %d sources", job.Name, len(watchers))
+
+	run := runJob(client, job, labels, tracker, opts, mtx, nil)
+	log.Printf("%s: backup finished: %s", job.Name, run.Result)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case sig := <-sigs:
+			log.Printf("received %s: stopping", sig)
+			return nil
+		case <-ticker.C:
+		}
+
+		// collect the changes from each source
+		due := make(map[string]bool)
+		changed := make(map[string][]string)
+		for label, w := range watchers {
+			paths, overflow := w.Drain()
+			if overflow {
+				log.Printf("%s/%s: events lost, running a full scan", job.Name, label)
+				due[label] = true
+				continue
+			}
+			if len(paths) > 0 {
+				due[label] = true
+				changed[label] = paths
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		run := runJob(client, job, due, tracker, opts, mtx, changed)
+		log.Printf("%s: backup finished: %s", job.Name, run.Result)
+	}
+}
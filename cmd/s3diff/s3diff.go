@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/studio1767/s3backup/internal/cache"
+	"github.com/studio1767/s3backup/internal/diff"
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/manifest"
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+func main() {
+	// process the command line
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-p <profile>] [-s secrets-file] [-json] <bucket> <old-manifest> <new-manifest>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s -local <directory> [-job <job>] [options] <bucket> <manifest>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "A manifest is given by its key, or as <job>/<label> for the latest one.\n")
+		flag.PrintDefaults()
+	}
+
+	profile := flag.String("p", "default", "aws s3 credentials profile")
+	secrets_file := flag.String("s", "default", "yaml file containing secret passphrases to decrypt the manifests")
+	json_output := flag.Bool("json", false, "write the differences as json")
+	local := flag.String("local", "", "compare the manifest with this directory instead of another manifest")
+	jobname := flag.String("job", "", "with -local, scan the directory using the skip and extension rules of this job")
+	cache_dir := flag.String("cache", "default", "directory to cache manifests and jobs in, default '~/.s3bu/cache'; 'none' to disable")
+	cache_size := flag.String("cache-size", "1GB", "the most space the cache can use")
+	flag.Parse()
+
+	nargs := 3
+	if *local != "" {
+		nargs = 2
+	}
+	if flag.NArg() != nargs {
+		fmt.Fprintf(os.Stderr, "Error: incorrect arguments provided\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *jobname != "" && *local == "" {
+		fmt.Fprintf(os.Stderr, "Error: a job is only used with -local\n")
+		os.Exit(1)
+	}
+
+	bucket := flag.Arg(0)
+
+	// create the client
+	client, err := s3io.NewClient(*profile, bucket, "default", *secrets_file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err = cache.Wrap(client, *cache_dir, *cache_size)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the old snapshot is always a manifest
+	oreader, okey, err := open_manifest(client, flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(oreader.Name())
+	defer oreader.Close()
+
+	// and the new one either another manifest or the local directory
+	var ch <-chan *ops.EntryInfo
+	var nname string
+	if *local != "" {
+		jb := &job.Job{}
+		if *jobname != "" {
+			jb, _, err = job.Download(client, *jobname)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		nname = *local
+		ch = ops.NewSourceStream(ctx, ops.NewFsScanner(ctx, *local, jb), jb, ops.NewManifestScanner(ctx, oreader))
+
+		// the new and modified files are hashed to tell content changes from
+		//   metadata changes
+		ch = ops.NewHashGenerator(ctx, ch, *local)
+	} else {
+		nreader, nkey, err := open_manifest(client, flag.Arg(2))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(nreader.Name())
+		defer nreader.Close()
+
+		nname = nkey
+		ch = ops.NewStreamComparer(ctx, ops.NewManifestScanner(ctx, nreader), ops.NewManifestScanner(ctx, oreader))
+	}
+
+	// collect the changes
+	var changes []*diff.Entry
+	var summary diff.Summary
+	failed := 0
+
+	for ei := range ch {
+		if ei.Action == ops.Failed {
+			fmt.Fprintf(os.Stderr, "failed: %s: %s\n", ei.RelPath, ei.ActionMessage)
+			failed++
+			continue
+		}
+
+		e := diff.NewEntry(ei)
+		if e.Change == diff.Unchanged {
+			continue
+		}
+		changes = append(changes, e)
+		summary.Add(e)
+	}
+
+	if *json_output {
+		err = write_json(okey, nname, changes, &summary)
+	} else {
+		write_text(okey, nname, changes, &summary)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if failed > 0 {
+		os.Exit(2)
+	}
+}
+
+// open_manifest downloads the manifest given by its key, or the latest for a
+// job/label, returning the file and the key.
+func open_manifest(client s3io.Client, spec string) (*os.File, string, error) {
+	if strings.HasPrefix(spec, "manifests/") {
+		f, err := manifest.DownloadWithKey(client, spec)
+		return f, spec, err
+	}
+
+	jobname, label, ok := strings.Cut(spec, "/")
+	if !ok || jobname == "" || label == "" {
+		return nil, "", fmt.Errorf("expected a manifest key or job/label: %s", spec)
+	}
+	return manifest.Download(client, jobname, label)
+}
+
+func write_text(oname, nname string, changes []*diff.Entry, summary *diff.Summary) {
+	fmt.Printf("Comparing %s\n", oname)
+	fmt.Printf("     with %s\n", nname)
+	fmt.Printf("\n")
+
+	for _, e := range changes {
+		switch e.Change {
+		case diff.Added:
+			fmt.Printf("-    added: %s (%s bytes)\n", e.Path, humanize.Comma(e.New.Size))
+		case diff.Removed:
+			fmt.Printf("-  removed: %s (%s bytes)\n", e.Path, humanize.Comma(e.Old.Size))
+		case diff.Modified:
+			fmt.Printf("- modified: %s (%s -> %s bytes)\n", e.Path, humanize.Comma(e.Old.Size), humanize.Comma(e.New.Size))
+		case diff.Metadata:
+			var details []string
+			if e.Old.Mode != e.New.Mode {
+				details = append(details, fmt.Sprintf("mode %s -> %s", e.Old.Mode, e.New.Mode))
+			}
+			if !e.Old.ModTime.Equal(e.New.ModTime) {
+				details = append(details, fmt.Sprintf("modified %s -> %s", e.Old.ModTime.Local().Format(time.DateTime), e.New.ModTime.Local().Format(time.DateTime)))
+			}
+			fmt.Printf("- metadata: %s (%s)\n", e.Path, strings.Join(details, ", "))
+		}
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Diff Summary\n")
+	fmt.Printf("-      added: %d\n", summary.Added)
+	fmt.Printf("-    removed: %d\n", summary.Removed)
+	fmt.Printf("-   modified: %d\n", summary.Modified)
+	fmt.Printf("-   metadata: %d\n", summary.Metadata)
+	fmt.Printf("- size delta: %s bytes\n", humanize.Comma(summary.SizeDelta))
+}
+
+func write_json(oname, nname string, changes []*diff.Entry, summary *diff.Summary) error {
+	if changes == nil {
+		changes = []*diff.Entry{}
+	}
+
+	out := struct {
+		Old     string        `json:"old"`
+		New     string        `json:"new"`
+		Changes []*diff.Entry `json:"changes"`
+		Summary *diff.Summary `json:"summary"`
+	}{
+		Old:     oname,
+		New:     nname,
+		Changes: changes,
+		Summary: summary,
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&out)
+}
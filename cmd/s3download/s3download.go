@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -16,7 +17,7 @@ import (
 func main() {
 	// process the command line
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s  [-p <profile>] [-o] [-s secrets-file] [-i identities-file] <bucket> <key> <restore_root>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "Usage: %s  [-p <profile>] [-o] [-q quarantine-file] [-download-limit rate] [-s secrets-file] [-i identities-file] <bucket> <key> <restore_root>\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
 
@@ -24,6 +25,8 @@ func main() {
 	secrets_file := flag.String("s", "default", "yaml file containing secret passphrases to decrypt metadata files")
 	identities_file := flag.String("i", "default", "file containing identities to decrypt data files")
 	overwrite := flag.Bool("o", false, "overwrite any existing files")
+	quarantine := flag.String("q", "", "append the key to this file if the data fails verification")
+	download_limit := flag.String("download-limit", "", "limit the download rate, e.g. 2MB or 2MB@08:00-18:00")
 	flag.Parse()
 
 	if flag.NArg() != 3 {
@@ -42,6 +45,14 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if *download_limit != "" {
+		limit, err := s3io.ParseRateLimit(*download_limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client.SetDownloadLimit(limit)
+	}
+
 	// run some sanity checks on the restore root
 	st, err := os.Stat(restore_root)
 	if err != nil {
@@ -59,15 +70,16 @@ func main() {
 			log.Fatal("the restore root is not a directory")
 		}
 	}
+	client.SetStagingDir(restore_root)
 
 	// run the restore for the manifest
-	err = download(client, key, restore_root, *overwrite)
+	err = download(client, key, restore_root, *overwrite, *quarantine)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func download(client s3io.Client, key, restore_root string, overwrite bool) error {
+func download(client s3io.Client, key, restore_root string, overwrite bool, quarantine string) error {
 
 	fmt.Printf("Processing %s\n", key)
 
@@ -93,10 +105,23 @@ func download(client s3io.Client, key, restore_root string, overwrite bool) erro
 	}
 	defer sink.Close()
 
-	// download the file
-	size, err := client.Download(key, sink)
+	// download the file, verifying the content of data keys against their hash
+	var size int64
+	if hash := s3io.HashFromKey(key); hash != "" {
+		size, err = s3io.DownloadVerified(client, key, hash, sink)
+	} else {
+		size, err = client.Download(key, sink)
+	}
 	if err != nil {
 		os.Remove(fpath)
+
+		var mismatch *s3io.ErrHashMismatch
+		if quarantine != "" && errors.As(err, &mismatch) {
+			qerr := s3io.Quarantine(quarantine, mismatch)
+			if qerr != nil {
+				fmt.Printf("- failed to quarantine: %s\n", qerr)
+			}
+		}
 		return fmt.Errorf("Download failed: %w", err)
 	}
 
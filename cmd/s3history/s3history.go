@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/studio1767/s3backup/internal/cache"
+	"github.com/studio1767/s3backup/internal/history"
+	"github.com/studio1767/s3backup/internal/manifest"
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/restore"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+func main() {
+	// process the command line
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-p <profile>] [-s secrets-file] [-json] <bucket> <pattern> [<job>[/<label>]]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s -restore <hash> -to <path> [-o] [-i identities-file] [options] <bucket> <pattern> [<job>[/<label>]]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+
+	profile := flag.String("p", "default", "aws s3 credentials profile")
+	secrets_file := flag.String("s", "default", "yaml file containing secret passphrases to decrypt the manifests")
+	identities_file := flag.String("i", "default", "file containing identities to decrypt data")
+	json_output := flag.Bool("json", false, "write the history as json")
+	version := flag.String("restore", "", "restore the version with this hash, or a unique prefix of it")
+	to := flag.String("to", "", "the path to restore the version to; a directory gets the file's name")
+	overwrite := flag.Bool("o", false, "overwrite the file if it already exists")
+	cache_dir := flag.String("cache", "default", "directory to cache manifests and jobs in, default '~/.s3bu/cache'; 'none' to disable")
+	cache_size := flag.String("cache-size", "1GB", "the most space the cache can use")
+	flag.Parse()
+
+	if flag.NArg() != 2 && flag.NArg() != 3 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect arguments provided\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if (*version == "") != (*to == "") {
+		fmt.Fprintf(os.Stderr, "Error: -restore and -to must be used together\n")
+		os.Exit(1)
+	}
+	if *version != "" && len(*version) < 6 {
+		fmt.Fprintf(os.Stderr, "Error: use at least 6 characters of the hash to restore\n")
+		os.Exit(1)
+	}
+
+	bucket := flag.Arg(0)
+	pattern, err := regexp.Compile(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the manifests to search; all of them, a job's or a source's
+	prefix := "manifests/"
+	if flag.NArg() == 3 {
+		prefix = fmt.Sprintf("manifests/%s/", flag.Arg(2))
+	}
+
+	// create the client
+	client, err := s3io.NewClient(*profile, bucket, *identities_file, *secrets_file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err = cache.Wrap(client, *cache_dir, *cache_size)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h, err := search(client, prefix, pattern)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *version != "" {
+		err := restore_version(client, h, *version, *to, *overwrite)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *json_output {
+		err = write_json(h.Files())
+	} else {
+		write_text(h.Files())
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// search scans every manifest under the prefix for the paths matching the pattern.
+func search(client s3io.Client, prefix string, pattern *regexp.Regexp) (*history.History, error) {
+	keys, err := client.ListMatching(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// the keys are in time order for each source
+	var snaps []*manifest.Snapshot
+	for _, key := range keys {
+		snap, err := manifest.ParseKey(key)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	if len(snaps) == 0 {
+		return nil, fmt.Errorf("no manifests found under %s", prefix)
+	}
+
+	fmt.Fprintf(os.Stderr, "Searching %d manifests\n", len(snaps))
+
+	h := history.New()
+	for _, snap := range snaps {
+		mreader, err := manifest.DownloadWithKey(client, snap.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", snap.Key, err)
+		}
+
+		for ei := range ops.NewManifestScanner(context.Background(), mreader) {
+			if pattern.MatchString(ei.RelPath) {
+				h.Add(snap, ei)
+			}
+		}
+		mreader.Close()
+		os.Remove(mreader.Name())
+	}
+
+	return h, nil
+}
+
+// restore_version downloads the version to the path.
+func restore_version(client s3io.Client, h *history.History, prefix, to string, overwrite bool) error {
+	file, v, err := h.Find(prefix)
+	if err != nil {
+		return err
+	}
+
+	if st, err := os.Stat(to); err == nil && st.IsDir() {
+		to = filepath.Join(to, filepath.Base(file.Path))
+	}
+	if _, err := os.Lstat(to); err == nil && overwrite == false {
+		return fmt.Errorf("%s already exists; use -o to overwrite it", to)
+	}
+
+	info := ops.EntryInfo{
+		RelPath: file.Path,
+		Hash:    v.Hash,
+		RawSize: v.Size,
+		ModTime: v.ModTime,
+		Mode:    os.FileMode(v.Mode),
+	}
+	_, err = restore.File(client, &info, to)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %s from %s to %s (%s bytes)\n", file.Path, v.First.Time.Format(time.DateTime), to, humanize.Comma(v.Size))
+	return nil
+}
+
+func write_text(files []*history.File) {
+	if len(files) == 0 {
+		fmt.Printf("No matching files found\n")
+		return
+	}
+
+	for _, file := range files {
+		fmt.Printf("%s/%s: %s\n", file.Job, file.Label, file.Path)
+		for _, v := range file.Versions {
+			fmt.Printf("- %s  %s - %s  %d snapshots  %s bytes\n",
+				v.Hash[:12],
+				v.First.Time.Format(time.DateTime),
+				v.Last.Time.Format(time.DateTime),
+				v.Snapshots,
+				humanize.Comma(v.Size))
+		}
+		fmt.Printf("\n")
+	}
+}
+
+func write_json(files []*history.File) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(files)
+}
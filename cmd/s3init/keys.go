@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// default_path returns the path of the file in '~/.s3bu' for 'default'.
+func default_path(fpath, name string) (string, error) {
+	if fpath != "default" {
+		return fpath, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(u.HomeDir, ".s3bu", name), nil
+}
+
+// write_private writes the file readable only by its owner, creating the directory
+// if needed. It won't replace an existing file.
+func write_private(fpath string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(fpath), 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fpath)
+	}
+	return err
+}
+
+// check_private checks an existing file is only accessible by its owner.
+func check_private(fpath string) error {
+	info, err := os.Stat(fpath)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("permissions on %s are too open: %#o", fpath, info.Mode().Perm())
+	}
+	return nil
+}
+
+// identities loads the identities file, or generates a new keypair into it if it
+// doesn't exist, returning the contents of the matching recipients file and whether
+// the file was created.
+func identities(fpath string) (string, bool, error) {
+	data, err := os.ReadFile(fpath)
+	if errors.Is(err, os.ErrNotExist) {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return "", false, err
+		}
+
+		recipient := identity.Recipient().String()
+		content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), recipient, identity)
+		err = write_private(fpath, []byte(content))
+		if err != nil {
+			return "", false, err
+		}
+		return recipient + "\n", true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	// an existing file is used as it is, so a keypair can be shared by repositories
+	err = check_private(fpath)
+	if err != nil {
+		return "", false, err
+	}
+	ids, err := age.ParseIdentities(strings.NewReader(string(data)))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse %s: %w", fpath, err)
+	}
+
+	var recipients strings.Builder
+	for _, id := range ids {
+		x25519, ok := id.(*age.X25519Identity)
+		if !ok {
+			continue
+		}
+		recipients.WriteString(x25519.Recipient().String())
+		recipients.WriteString("\n")
+	}
+	if recipients.Len() == 0 {
+		return "", false, fmt.Errorf("no X25519 identities found in %s", fpath)
+	}
+	return recipients.String(), false, nil
+}
+
+// random_id returns a random string of letters and digits.
+func random_id(n int) (string, error) {
+	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	id := make([]byte, n)
+	for i := range id {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", err
+		}
+		id[i] = chars[idx.Int64()]
+	}
+	return string(id), nil
+}
+
+// secrets generates a secrets file with a single passphrase if it doesn't exist,
+// returning whether the file was created.
+func secrets(fpath string) (bool, error) {
+	_, err := os.Stat(fpath)
+	if err == nil {
+		return false, check_private(fpath)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	id, err := random_id(16)
+	if err != nil {
+		return false, err
+	}
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return false, err
+	}
+
+	type Secret struct {
+		Id         string `yaml:"id"`
+		Passphrase string `yaml:"passphrase"`
+	}
+	data, err := yaml.Marshal([]Secret{
+		{
+			Id:         id,
+			Passphrase: base64.RawURLEncoding.EncodeToString(key),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, write_private(fpath, data)
+}
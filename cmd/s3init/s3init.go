@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// the starter job backs up a single directory with the common clutter excluded
+const starterJob = `---
+# the directories to scan for inputs
+sources:
+- path: %q
+  label: %q
+
+# list of file extensions to exclude
+exclude_extensions:
+- .DS_Store
+
+# top level directories to exclude
+# exclude_top_dirs:
+# - Downloads
+`
+
+func main() {
+	// process the command line
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-p <profile>] [-i identities-file] [-s secrets-file] [-create] [-job <jobname> -source <path> [-label <label>]] <bucket>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+
+	profile := flag.String("p", "default", "aws s3 credentials profile")
+	identities_file := flag.String("i", "default", "identities file to create, or use if it exists; default '~/.s3bu/identities.txt'")
+	secrets_file := flag.String("s", "default", "secrets file to create, or use if it exists; default '~/.s3bu/secrets.yml'")
+	create := flag.Bool("create", false, "create the bucket if it doesn't exist")
+	jobname := flag.String("job", "", "upload a starter job with this name")
+	source := flag.String("source", "", "the directory for the starter job to back up")
+	label := flag.String("label", "", "the label of the starter job's source; defaults to the directory name")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect arguments provided\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if (*jobname == "") != (*source == "") {
+		fmt.Fprintf(os.Stderr, "Error: -job and -source must be used together\n")
+		os.Exit(1)
+	}
+
+	bucket := flag.Arg(0)
+
+	// the local keys come first; existing ones are kept
+	ipath, err := default_path(*identities_file, "identities.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	recipients, created, err := identities(ipath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report(created, ipath, "generated keypair in", "using identities in")
+
+	spath, err := default_path(*secrets_file, "secrets.yml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	created, err = secrets(spath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report(created, spath, "generated secrets in", "using secrets in")
+
+	// then the bucket
+	created, err = s3io.InitRepo(*profile, bucket, recipients, *create)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report(created, "repo/recipients.txt", "uploaded", "found")
+
+	client, err := s3io.NewClient(*profile, bucket, ipath, spath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	created, err = write_config(client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report(created, s3io.RepoConfigKey, "uploaded", "found")
+
+	if *jobname != "" {
+		key, err := upload_job(client, *jobname, *source, *label)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("uploaded starter job to %s\n", key)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Keep a copy of %s somewhere safe; without it the backups can't be restored.\n", ipath)
+}
+
+func report(created bool, name, yes, no string) {
+	if created {
+		fmt.Printf("%s %s\n", yes, name)
+	} else {
+		fmt.Printf("%s %s\n", no, name)
+	}
+}
+
+// write_config uploads the repository marker, unless there's one already.
+func write_config(client s3io.Client) (bool, error) {
+	exists, err := client.Exists(s3io.RepoConfigKey)
+	if err != nil || exists {
+		return false, err
+	}
+
+	data, err := s3io.NewRepoConfig().Marshal()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = client.PutIfAbsent(s3io.RepoConfigKey, data)
+	var unsupported *s3io.ErrConditionNotSupported
+	if errors.As(err, &unsupported) {
+		_, err = client.Upload(s3io.RepoConfigKey, bytes.NewReader(data))
+	}
+	var failed *s3io.ErrPreconditionFailed
+	if errors.As(err, &failed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// upload_job uploads a starter job for the directory, unless the job exists.
+func upload_job(client s3io.Client, jobname, source, label string) (string, error) {
+	_, _, err := client.LatestMatching(fmt.Sprintf("jobs/%s/", jobname))
+	if err == nil {
+		return "", fmt.Errorf("job %s already exists", jobname)
+	}
+	var nomatch *s3io.ErrNoMatch
+	if !errors.As(err, &nomatch) {
+		return "", err
+	}
+
+	source, err = filepath.Abs(source)
+	if err != nil {
+		return "", err
+	}
+	if label == "" {
+		label = filepath.Base(source)
+	}
+	data := fmt.Sprintf(starterJob, source, label)
+
+	// make sure the paths didn't break the yaml
+	var jb job.Job
+	err = yaml.Unmarshal([]byte(data), &jb)
+	if err != nil {
+		return "", fmt.Errorf("failed to create starter job: %w", err)
+	}
+
+	return job.Upload(client, bytes.NewReader([]byte(data)), jobname)
+}
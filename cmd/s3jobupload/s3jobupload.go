@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 
+	yaml "gopkg.in/yaml.v3"
+
 	"github.com/studio1767/s3backup/internal/job"
 	"github.com/studio1767/s3backup/internal/s3io"
 )
@@ -49,15 +52,28 @@ func main() {
 
 func upload(client s3io.Client, jobname, jobfile string) (string, error) {
 
-	// open the file
-	source, err := os.Open(jobfile)
+	// read the file and check it parses
+	data, err := os.ReadFile(jobfile)
+	if err != nil {
+		return "", err
+	}
+
+	var jb job.Job
+	err = yaml.Unmarshal(data, &jb)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", jobfile, err)
+	}
+	jb.Name = jobname
+
+	// the job is stored with the metadata storage class and tags
+	_, metadata, err := jb.UploadPolicies("")
 	if err != nil {
 		return "", err
 	}
-	defer source.Close()
+	client.SetUploadPolicy("jobs/", metadata)
 
-	// download the file
-	key, err := job.Upload(client, source, jobname)
+	// upload the file
+	key, err := job.Upload(client, bytes.NewReader(data), jobname)
 	if err != nil {
 		return "", err
 	}
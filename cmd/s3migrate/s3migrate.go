@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/lock"
+	"github.com/studio1767/s3backup/internal/migrate"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+func main() {
+	// process the command line
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-p <profile>] [-s secrets-file] [-n] [-lock-wait duration] <bucket>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+
+	profile := flag.String("p", "default", "aws s3 credentials profile")
+	secrets_file := flag.String("s", "default", "yaml file containing secret passphrases for metadata")
+	var dry_run bool
+	flag.BoolVar(&dry_run, "n", false, "dry run: report the upgrade steps without changing the bucket")
+	flag.BoolVar(&dry_run, "dry-run", false, "the same as -n")
+	lock_wait := flag.Duration("lock-wait", 0, "how long to wait for backups and restores to finish")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect arguments provided\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	bucket := flag.Arg(0)
+
+	// create the client; this fails for repositories newer than we know
+	client, err := s3io.NewClient(*profile, bucket, "default", *secrets_file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rc := client.RepoConfig()
+	steps, err := migrate.Plan(rc.Version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(steps) == 0 {
+		fmt.Printf("repository is at version %d; nothing to do\n", rc.Version)
+		return
+	}
+
+	if dry_run {
+		fmt.Printf("dry run: upgrading repository from version %d to %d\n", rc.Version, s3io.RepoVersion)
+		err = migrate.Run(client, rc, steps, true, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// nothing else can use the repository while it's upgraded
+	locker := lock.NewLocker(client, filepath.Base(os.Args[0]), lock.DefaultTTL, *lock_wait)
+	repo_lock, err := locker.Acquire(lock.RepoScope, lock.Exclusive)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	start := time.Now()
+	fmt.Printf("upgrading repository from version %d to %d\n", rc.Version, s3io.RepoVersion)
+	err = migrate.Run(client, rc, steps, false, os.Stdout)
+
+	lerr := repo_lock.Release()
+	if err == nil {
+		err = lerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("upgraded in %s\n", time.Since(start).Round(time.Second))
+}
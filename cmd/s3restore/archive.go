@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/studio1767/s3backup/internal/manifest"
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/progress"
+	"github.com/studio1767/s3backup/internal/report"
+	"github.com/studio1767/s3backup/internal/restore"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// restore_archive streams the selected files into an archive written to the file,
+// or stdout if it's '-'. Any failure leaves the archive incomplete, so the restore
+// stops and a partially written file is removed.
+func restore_archive(client s3io.Client, mkey string, sel *restore.Selector, mapper *restore.Mapper, archive_file, format string, tracker *progress.Tracker, rpt *report.Source, opts *options) error {
+	if format == "" {
+		format = restore.FormatFromName(archive_file)
+	}
+	if format == "" {
+		format = restore.FormatTar
+	}
+
+	// download the manifest file
+	mreader, err := manifest.DownloadWithKey(client, mkey)
+	if err != nil {
+		return err
+	}
+	defer mreader.Close()
+	defer os.Remove(mreader.Name())
+
+	tracker.Printf("Processing %s\n", mkey)
+
+	// count the matching entries for the progress estimate
+	tracker.Start(mkey)
+	defer tracker.Stop()
+
+	var est_files, est_bytes int64
+	for info := range ops.NewManifestScanner(context.Background(), io.NopCloser(mreader)) {
+		if _, ok := selected(sel, mapper, info); ok {
+			est_files++
+			est_bytes += info.RawSize
+		}
+	}
+	tracker.SetEstimate(est_files, est_bytes)
+
+	_, err = mreader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// open the output
+	var out io.Writer = os.Stdout
+	if archive_file != "-" {
+		f, err := os.Create(archive_file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	archive, err := restore.NewArchive(out, format)
+	if err != nil {
+		if archive_file != "-" {
+			os.Remove(archive_file)
+		}
+		return err
+	}
+
+	// stream the selected files into the archive
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	num_total := 0
+	var total_bytes int64 = 0
+
+	for info := range ops.NewManifestScanner(ctx, mreader) {
+		dest, ok := selected(sel, mapper, info)
+		if !ok {
+			continue
+		}
+
+		size, err := archive.Add(client, info, dest)
+		tracker.Add(info.RawSize, 0, size)
+		if err != nil {
+			tracker.Printf("-      failed: %s: %s\n", info.RelPath, err)
+
+			var mismatch *s3io.ErrHashMismatch
+			if opts.quarantine != "" && errors.As(err, &mismatch) {
+				qerr := s3io.Quarantine(opts.quarantine, mismatch)
+				if qerr != nil {
+					tracker.Printf("-      failed to quarantine: %s\n", qerr)
+				}
+			}
+
+			info.Action = ops.Failed
+			info.ActionMessage = err.Error()
+			rpt.Add(info, size)
+
+			archive.Close()
+			if archive_file != "-" {
+				os.Remove(archive_file)
+			}
+			return fmt.Errorf("archive incomplete: %s: %w", info.RelPath, err)
+		}
+
+		tracker.Printf("-    archived: %s (%s bytes)\n", dest, humanize.Comma(info.RawSize))
+		info.Action = ops.Downloaded
+		rpt.Add(info, size)
+
+		num_total += 1
+		total_bytes += info.RawSize
+	}
+
+	err = archive.Close()
+	if err != nil {
+		if archive_file != "-" {
+			os.Remove(archive_file)
+		}
+		return err
+	}
+	tracker.Stop()
+
+	tracker.Printf("\n")
+	tracker.Printf("Archive Summary\n")
+	tracker.Printf("-        format: %s\n", format)
+	tracker.Printf("-   total files: %d\n", num_total)
+	tracker.Printf("-   total bytes: %s\n", humanize.Comma(total_bytes))
+	tracker.Printf("\n")
+
+	return nil
+}
@@ -1,49 +1,164 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strings"
+	"time"
 
 	humanize "github.com/dustin/go-humanize"
 
+	"github.com/studio1767/s3backup/internal/cache"
+	"github.com/studio1767/s3backup/internal/lock"
 	"github.com/studio1767/s3backup/internal/manifest"
 	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/progress"
+	"github.com/studio1767/s3backup/internal/report"
+	"github.com/studio1767/s3backup/internal/restore"
 	"github.com/studio1767/s3backup/internal/s3io"
 )
 
 func main() {
 	// process the command line
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s  [-p <profile>] [-c] [-f] [-o] [-s secrets-file] [-i identities-file] <bucket> <manifest-key> <restore-root> [<pattern>]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "Usage: %s  [-p <profile>] [-c] [-f] [-o] [-conflict policy] [-sync] [-y] [-P] [-j workers] [-l] [-q quarantine-file] [-include glob] [-exclude glob] [-files-from file] [-strip-components n] [-rename-prefix old=new] [-download-limit rate] [-thaw] [-thaw-tier tier] [-thaw-days n] [-thaw-state file] [-thaw-poll interval] [-thaw-nowait] [-lock-wait duration] [-no-lock] [-json] [-report-file file] [-s secrets-file] [-i identities-file] <bucket> <manifest-key> <restore-root> [<pattern>]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s -archive file|- [-format format] [options] <bucket> <manifest-key> [<pattern>]\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
 
 	profile := flag.String("p", "default", "aws s3 credentials profile")
 	check_mode := flag.Bool("c", false, "run in check mode")
 	force := flag.Bool("f", false, "force download even if destination not empty")
-	overwrite := flag.Bool("o", false, "overwrite any existing files")
+	overwrite := flag.Bool("o", false, "overwrite any existing files; the same as -conflict overwrite")
+	conflict := flag.String("conflict", "", "what to do with existing files: skip, overwrite, overwrite-if-different, keep-newer or rename (default skip)")
+	sync_mode := flag.Bool("sync", false, "make the restore root match the manifest, deleting extra files; implies -f, and only allows the overwrite and overwrite-if-different policies")
+	yes := flag.Bool("y", false, "don't ask for confirmation before deleting extra files in sync mode")
+	show_progress := flag.Bool("P", false, "show progress with totals, throughput and ETA")
+	workers := flag.Int("j", restore.DefaultWorkers, "number of files to download concurrently")
+	link := flag.Bool("l", false, "hardlink files with the same content instead of copying them")
+	quarantine := flag.String("q", "", "append the keys of objects that fail verification to this file")
 	secrets_file := flag.String("s", "default", "yaml file containing secret passphrases to decrypt the manifests")
 	identities_file := flag.String("i", "default", "file containing identities to decrypt data")
+	json_report := flag.Bool("json", false, "write a json report to stdout; other output goes to stderr")
+	report_file := flag.String("report-file", "", "write a json report to this file")
+	download_limit := flag.String("download-limit", "", "limit the download rate, e.g. 2MB or 2MB@08:00-18:00")
+	var includes, excludes, renames stringList
+	flag.Var(&includes, "include", "only restore paths matching this glob; can be repeated")
+	flag.Var(&excludes, "exclude", "don't restore paths matching this glob; can be repeated")
+	files_from := flag.String("files-from", "", "only restore the paths listed in this file, one per line")
+	strip_components := flag.Int("strip-components", 0, "strip this many leading directories from the restored paths")
+	flag.Var(&renames, "rename-prefix", "restore paths under 'old' under 'new' instead, given as old=new; can be repeated")
+	archive_file := flag.String("archive", "", "write the files into an archive instead of a directory; '-' for stdout")
+	archive_format := flag.String("format", "", "archive format: tar, tar.gz, tar.zst or zip (default from the file name, else tar)")
+	thaw := flag.Bool("thaw", false, "request restores of archived objects and wait for them to thaw before restoring")
+	thaw_tier := flag.String("thaw-tier", "Bulk", "the retrieval tier for thawing: Bulk, Standard or Expedited")
+	thaw_days := flag.Int("thaw-days", 7, "number of days to keep the thawed copies")
+	thaw_state := flag.String("thaw-state", "", "keep the thaw progress in this file so an interrupted wait can be resumed")
+	thaw_poll := flag.Duration("thaw-poll", 15*time.Minute, "how often to check on thawing objects")
+	thaw_nowait := flag.Bool("thaw-nowait", false, "request the thaws and exit rather than wait for them")
+	cache_dir := flag.String("cache", "default", "directory to cache manifests and jobs in, default '~/.s3bu/cache'; 'none' to disable")
+	cache_size := flag.String("cache-size", "1GB", "the most space the cache can use")
+	lock_wait := flag.Duration("lock-wait", 0, "how long to wait for a lock held by another run")
+	no_lock := flag.Bool("no-lock", false, "don't lock the bucket while restoring")
 	flag.Parse()
 
-	if flag.NArg() != 3 && flag.NArg() != 4 {
+	// in archive mode there's no restore root
+	archive_mode := *archive_file != ""
+	nargs := 3
+	if archive_mode {
+		nargs = 2
+	}
+	if flag.NArg() != nargs && flag.NArg() != nargs+1 {
 		fmt.Fprintf(os.Stderr, "Error: incorrect arguments provided\n")
 		flag.Usage()
 		os.Exit(1)
 	}
+	if archive_mode && (*check_mode || *sync_mode) {
+		fmt.Fprintf(os.Stderr, "Error: check and sync modes can't be used with an archive\n")
+		os.Exit(1)
+	}
+	if *thaw && *check_mode {
+		fmt.Fprintf(os.Stderr, "Error: objects can't be thawed in check mode\n")
+		os.Exit(1)
+	}
+	if *archive_file == "-" && *json_report {
+		fmt.Fprintf(os.Stderr, "Error: the archive and json report can't both be written to stdout\n")
+		os.Exit(1)
+	}
 
 	bucket := flag.Arg(0)
 	manifest_key := flag.Arg(1)
-	restore_root := flag.Arg(2)
+	restore_root := ""
+	if !archive_mode {
+		restore_root = flag.Arg(2)
+	}
 
 	pattern := ".*"
-	if flag.NArg() == 4 {
-		pattern = flag.Arg(3)
+	if flag.NArg() == nargs+1 {
+		pattern = flag.Arg(nargs)
+	}
+
+	// the selection of files and where to restore them to
+	sel, err := restore.NewSelector(pattern, includes, excludes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *files_from != "" {
+		err := sel.LoadFiles(*files_from)
+		if err != nil {
+			log.Fatalf("failed to read files list: %s", err)
+		}
+	}
+	mapper, err := restore.NewMapper(*strip_components, renames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *sync_mode && mapper.Strips() {
+		fmt.Fprintf(os.Stderr, "Error: sync mode can't be used with -strip-components, as the extra files can't be matched to the manifest\n")
+		os.Exit(1)
+	}
+
+	// what to do with existing files; sync mode only replaces the files that differ
+	opts := options{
+		checkMode:  *check_mode,
+		policy:     restore.PolicySkip,
+		quarantine: *quarantine,
+		sync:       *sync_mode,
+		yes:        *yes,
+	}
+	if *sync_mode {
+		opts.policy = restore.PolicyIfDifferent
+	}
+	if *overwrite {
+		opts.policy = restore.PolicyOverwrite
+	}
+	if *conflict != "" {
+		opts.policy, err = restore.ParsePolicy(*conflict)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *sync_mode && opts.policy != restore.PolicyOverwrite && opts.policy != restore.PolicyIfDifferent {
+		fmt.Fprintf(os.Stderr, "Error: sync mode can't be used with the %s policy, as it would leave files that don't match the manifest\n", opts.policy)
+		os.Exit(1)
+	}
+
+	// the tier and days to thaw archived objects with
+	if *thaw {
+		*thaw_tier, err = s3io.ParseThawTier(*thaw_tier)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *thaw_days < 1 {
+			log.Fatalf("thawed copies must be kept for at least a day")
+		}
 	}
 
 	// create the client
@@ -51,50 +166,150 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	client, err = cache.Wrap(client, *cache_dir, *cache_size)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *download_limit != "" {
+		limit, err := s3io.ParseRateLimit(*download_limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client.SetDownloadLimit(limit)
+	}
 
 	if client.HasIdentities() == false {
 		log.Fatal(&s3io.ErrIdentitiesNotFound{})
 	}
 
-	// run some sanity checks on the restore root
+	var thawer *restore.Thawer
+	if *thaw {
+		thawer, err = restore.NewThawer(client, *thaw_tier, *thaw_days, *thaw_state)
+		if err != nil {
+			log.Fatalf("failed to load thaw state: %s", err)
+		}
+	}
+
+	// run some sanity checks on the restore root; it must be empty unless
+	//   we're only checking, forcing the download or syncing
+	if archive_mode == false {
+		must_be_empty := *check_mode == false && *force == false && *sync_mode == false
+		err := check_restore_root(restore_root, must_be_empty)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// large files are staged next to where they're restored to; archives
+		//   are streamed straight through
+		client.SetStagingDir(restore_root)
+	}
+
+	// run the restore for the manifest
+	out := os.Stdout
+	if *json_report || *archive_file == "-" {
+		out = os.Stderr
+	}
+	tracker := progress.NewTracker(out, *show_progress)
+
+	run := report.NewRun(filepath.Base(os.Args[0]), bucket, "")
+	rpt := report.NewSource("", "", restore_root)
+	rpt.Manifest = manifest_key
+	run.Add(rpt)
+
+	// the repository is locked shared, so maintenance can't remove content while
+	//   it's being restored
+	var repo_lock *lock.Lock
+	if *no_lock == false {
+		locker := lock.NewLocker(client, filepath.Base(os.Args[0]), lock.DefaultTTL, *lock_wait)
+		repo_lock, err = locker.Acquire(lock.RepoScope, lock.Shared)
+	}
+
+	// get any archived objects thawed first; nothing can be restored until they are
+	if err == nil && thawer != nil {
+		err = thaw_manifest(client, manifest_key, sel, mapper, thawer, *thaw_poll, !*thaw_nowait, tracker)
+	}
+
+	if err == nil {
+		if archive_mode {
+			rpt.Path = *archive_file
+			err = restore_archive(client, manifest_key, sel, mapper, *archive_file, *archive_format, tracker, rpt, &opts)
+		} else {
+			engine := restore.New(client, restore_root, *workers, *link)
+			engine.SetMapper(mapper)
+
+			err = restore_manifest(client, engine, manifest_key, sel, mapper, restore_root, tracker, rpt, &opts)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+	}
+	if repo_lock != nil {
+		lerr := repo_lock.Release()
+		if lerr != nil {
+			log.Print(lerr)
+		}
+	}
+	rpt.Finish(err)
+
+	// write out the reports
+	run.Finish()
+
+	if *report_file != "" {
+		err := run.WriteFile(*report_file)
+		if err != nil {
+			log.Printf("failed to write report: %s", err)
+		}
+	}
+	if *json_report {
+		err := run.Write(os.Stdout)
+		if err != nil {
+			log.Printf("failed to write report: %s", err)
+		}
+	}
+
+	os.Exit(run.ExitCode())
+}
+
+func check_restore_root(restore_root string, must_be_empty bool) error {
 	st, err := os.Stat(restore_root)
 	if err != nil {
 		if os.IsNotExist(err) {
 			err := os.Mkdir(restore_root, 0755)
 			if err != nil {
-				log.Fatalf("failed to create restore root: %s", err)
+				return fmt.Errorf("failed to create restore root: %w", err)
 			}
-		} else {
-			log.Fatalf("failed to stat restore root: %s", err)
-		}
-
-	} else {
-		if st.IsDir() == false {
-			log.Fatal("the restore root is not a directory")
+			return nil
 		}
+		return fmt.Errorf("failed to stat restore root: %w", err)
+	}
+	if st.IsDir() == false {
+		return fmt.Errorf("the restore root is not a directory")
 	}
 
-	// make sure the restore root is empty ...
-	//   ...not if we're only checking
-	//   ...not if we're forcing the download
-	if *check_mode == false && *force == false {
+	if must_be_empty {
 		entries, err := os.ReadDir(restore_root)
 		if err != nil {
-			log.Fatalf("failed to read restore root: %s", err)
+			return fmt.Errorf("failed to read restore root: %w", err)
 		}
 		if len(entries) != 0 {
-			log.Fatal("restore root is not empty; use -f to force restore")
+			return fmt.Errorf("restore root is not empty; use -f to force restore")
 		}
 	}
 
-	// run the restore for the manifest
-	err = restore_manifest(client, manifest_key, pattern, restore_root, *check_mode, *overwrite)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return nil
+}
+
+// options controls how the files are restored.
+type options struct {
+	checkMode  bool
+	policy     restore.Policy
+	quarantine string
+	sync       bool
+	yes        bool
 }
 
-func restore_manifest(client s3io.Client, mkey string, pattern string, restore_root string, check_mode bool, overwrite bool) error {
+func restore_manifest(client s3io.Client, engine *restore.Engine, mkey string, sel *restore.Selector, mapper *restore.Mapper, restore_root string, tracker *progress.Tracker, rpt *report.Source, opts *options) error {
 	// download the manifest file
 	mreader, err := manifest.DownloadWithKey(client, mkey)
 	if err != nil {
@@ -103,14 +318,57 @@ func restore_manifest(client s3io.Client, mkey string, pattern string, restore_r
 	defer mreader.Close()
 	defer os.Remove(mreader.Name())
 
-	fmt.Printf("Processing %s\n", mkey)
+	tracker.Printf("Processing %s\n", mkey)
 
-	// create the pattern matcher
-	regex := regexp.MustCompile(pattern)
+	// count the matching entries for the progress estimate
+	tracker.Start(mkey)
+	defer tracker.Stop()
 
-	// start the scanner
+	var est_files, est_bytes int64
+	wanted := make(map[string]bool)
+	for info := range ops.NewManifestScanner(context.Background(), io.NopCloser(mreader)) {
+		if dest, ok := selected(sel, mapper, info); ok {
+			est_files++
+			est_bytes += info.RawSize
+			wanted[filepath.Clean(dest)] = true
+		}
+	}
+	tracker.SetEstimate(est_files, est_bytes)
+
+	_, err = mreader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// in sync mode, find the extra files and confirm their deletion before starting
+	var extras []string
+	if opts.sync {
+		extras, err = restore.FindExtras(restore_root, func(relpath string) bool {
+			return wanted[relpath] || !restore.InSync(sel, mapper, relpath)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan restore root: %w", err)
+		}
+		for _, relpath := range extras {
+			tracker.Printf("-       extra: %s\n", relpath)
+		}
+		if len(extras) > 0 && opts.checkMode == false && opts.yes == false {
+			tracker.Stop()
+			if confirm(fmt.Sprintf("Delete %d extra files from %s?", len(extras), restore_root)) == false {
+				return fmt.Errorf("sync cancelled")
+			}
+			tracker.Start(mkey)
+			tracker.SetEstimate(est_files, est_bytes)
+		}
+	}
+
+	// start the scanner and the restore engine; the engine reads all the
+	//   entries to restore before it starts
 	ch := ops.NewManifestScanner(context.Background(), mreader)
 
+	todo := make(chan *ops.EntryInfo, 10)
+	results := engine.Restore(context.Background(), todo)
+
 	// process the scanned results
 	num_total := 0
 	var total_bytes int64 = 0
@@ -120,86 +378,139 @@ func restore_manifest(client s3io.Client, mkey string, pattern string, restore_r
 	var skip_bytes int64 = 0
 
 	for info := range ch {
-		matches := regex.FindStringSubmatch(info.RelPath)
-		if matches == nil {
+		dest, ok := selected(sel, mapper, info)
+		if !ok {
 			continue
 		}
 
 		num_total += 1
 		total_bytes += info.RawSize
 
-		if check_mode == true {
-			fmt.Printf("- found: %s (%s bytes)\n", info.RelPath, humanize.Comma(info.RawSize))
+		if opts.checkMode == true {
+			if dest != info.RelPath {
+				tracker.Printf("- found: %s -> %s (%s bytes)\n", info.RelPath, dest, humanize.Comma(info.RawSize))
+			} else {
+				tracker.Printf("- found: %s (%s bytes)\n", info.RelPath, humanize.Comma(info.RawSize))
+			}
+			tracker.Add(info.RawSize, 0, 0)
+			rpt.Add(info, 0)
 			continue
 		}
 
-		// check the download file
-		fpath := filepath.Join(restore_root, info.RelPath)
-		if overwrite == false {
-			_, err := os.Stat(fpath)
-			if err == nil {
-				fmt.Printf("-    skipping: %s (%s bytes)\n", info.RelPath, humanize.Comma(info.RawSize))
-				num_skipped += 1
-				skip_bytes += info.RawSize
-				continue
-			}
+		// check the download file against the conflict policy
+		fpath := filepath.Join(restore_root, dest)
+		restorable, err := restore.Resolve(opts.policy, info, fpath)
+		if err != nil {
+			tracker.Printf("-      failed: %s: %s\n", info.RelPath, err)
+			tracker.Add(info.RawSize, 0, 0)
+			info.Action = ops.Failed
+			info.ActionMessage = err.Error()
+			rpt.Add(info, 0)
+			num_fails += 1
+			fail_bytes += info.RawSize
+			continue
+		}
+		if restorable == false {
+			tracker.Printf("-    skipping: %s (%s bytes)\n", info.RelPath, humanize.Comma(info.RawSize))
+			tracker.Add(info.RawSize, 0, 0)
+			info.Action = ops.Skipped
+			rpt.Add(info, 0)
+			num_skipped += 1
+			skip_bytes += info.RawSize
+			continue
 		}
 
-		fmt.Printf("- downloading: %s (%s bytes)\n", info.RelPath, humanize.Comma(info.RawSize))
-		_, err := restore_file(client, info, fpath)
-		if err != nil {
+		todo <- info
+	}
+	close(todo)
+
+	// process the restored files as they complete
+	for result := range results {
+		info := result.Info
+
+		tracker.Add(info.RawSize, 0, result.Transferred)
+		if result.Err != nil {
 			num_fails += 1
 			fail_bytes += info.RawSize
 
-			fmt.Printf(" - failed: %s", err)
+			tracker.Printf("-      failed: %s: %s\n", info.RelPath, result.Err)
+
+			// keep a record of corrupted objects
+			var mismatch *s3io.ErrHashMismatch
+			if opts.quarantine != "" && errors.As(result.Err, &mismatch) {
+				err := s3io.Quarantine(opts.quarantine, mismatch)
+				if err != nil {
+					tracker.Printf("-      failed to quarantine: %s\n", err)
+				}
+			}
+
+			info.Action = ops.Failed
+			info.ActionMessage = result.Err.Error()
+		} else {
+			tracker.Printf("-    restored: %s (%s bytes)\n", info.RelPath, humanize.Comma(info.RawSize))
+
+			info.Action = ops.Downloaded
 		}
+		rpt.Add(info, result.Transferred)
 	}
+	tracker.Stop()
 
-	fmt.Println()
-	fmt.Printf("Restore Summary\n")
-	fmt.Printf("-   total files: %d\n", num_total)
-	fmt.Printf("-   total bytes: %s\n", humanize.Comma(total_bytes))
-	fmt.Printf("- success files: %d\n", num_total-num_skipped-num_fails)
-	fmt.Printf("- success bytes: %s\n", humanize.Comma(total_bytes-skip_bytes-fail_bytes))
-	fmt.Printf("- skipped files: %d\n", num_skipped)
-	fmt.Printf("- skipped bytes: %s\n", humanize.Comma(skip_bytes))
-	fmt.Printf("-  failed files: %d\n", num_fails)
-	fmt.Printf("-  failed bytes: %s\n", humanize.Comma(fail_bytes))
-	fmt.Println()
+	// and finally remove the extra files
+	num_deleted := 0
+	if len(extras) > 0 && opts.checkMode == false {
+		err := restore.RemoveExtras(restore_root, extras)
+		if err != nil {
+			return fmt.Errorf("failed to remove extra files: %w", err)
+		}
+		num_deleted = len(extras)
+	}
+
+	tracker.Printf("\n")
+	tracker.Printf("Restore Summary\n")
+	tracker.Printf("-   total files: %d\n", num_total)
+	tracker.Printf("-   total bytes: %s\n", humanize.Comma(total_bytes))
+	tracker.Printf("- success files: %d\n", num_total-num_skipped-num_fails)
+	tracker.Printf("- success bytes: %s\n", humanize.Comma(total_bytes-skip_bytes-fail_bytes))
+	tracker.Printf("- skipped files: %d\n", num_skipped)
+	tracker.Printf("- skipped bytes: %s\n", humanize.Comma(skip_bytes))
+	tracker.Printf("-  failed files: %d\n", num_fails)
+	tracker.Printf("-  failed bytes: %s\n", humanize.Comma(fail_bytes))
+	if opts.sync {
+		tracker.Printf("-   extra files: %d\n", len(extras))
+		tracker.Printf("- deleted files: %d\n", num_deleted)
+	}
+	tracker.Printf("\n")
 
 	return nil
 }
 
-func restore_file(client s3io.Client, info *ops.EntryInfo, fpath string) (int64, error) {
-	// construct the key from the hash
-	key := fmt.Sprintf("data/%s/%s", info.Hash[:4], info.Hash)
+// confirm asks the question on the terminal and reports if the answer is yes.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
 
-	// create the directories to the file
-	fdir := filepath.Dir(fpath)
-	err := os.MkdirAll(fdir, 0755)
-	if err != nil {
-		return 0, err
-	}
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
 
-	// create the file
-	sink, err := os.Create(fpath)
-	if err != nil {
-		return 0, err
-	}
-	defer sink.Close()
+	return answer == "y" || answer == "yes"
+}
 
-	// download to the file
-	size, err := client.Download(key, sink)
-	if err != nil {
-		os.Remove(fpath)
-		return 0, err
+// selected reports if the entry is selected for restore, and the path under the
+// restore root to restore it to.
+func selected(sel *restore.Selector, mapper *restore.Mapper, info *ops.EntryInfo) (string, bool) {
+	if !sel.Match(info.RelPath) {
+		return "", false
 	}
+	return mapper.Map(info.RelPath)
+}
 
-	// set the file mode to match
-	err = os.Chmod(fpath, info.Mode)
-	if err != nil {
-		return 0, err
-	}
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
 
-	return size, nil
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
 }
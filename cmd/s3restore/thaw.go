@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/manifest"
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/progress"
+	"github.com/studio1767/s3backup/internal/restore"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// thaw_manifest gets the archived objects for the selected files thawed before the
+// restore starts. With wait set, it polls until they're all available; otherwise
+// it returns an error if any are still thawing so the restore can be run again later.
+func thaw_manifest(client s3io.Client, mkey string, sel *restore.Selector, mapper *restore.Mapper, thawer *restore.Thawer, poll time.Duration, wait bool, tracker *progress.Tracker) error {
+	// download the manifest file
+	mreader, err := manifest.DownloadWithKey(client, mkey)
+	if err != nil {
+		return err
+	}
+	defer mreader.Close()
+	defer os.Remove(mreader.Name())
+
+	// each object only needs to be thawed once
+	var keys []string
+	seen := make(map[string]bool)
+	for info := range ops.NewManifestScanner(context.Background(), mreader) {
+		if _, ok := selected(sel, mapper, info); !ok || seen[info.Hash] {
+			continue
+		}
+		seen[info.Hash] = true
+		keys = append(keys, fmt.Sprintf("data/%s/%s", info.Hash[:4], info.Hash))
+	}
+
+	tracker.Printf("Checking %d objects for %s\n", len(keys), mkey)
+
+	pending, err := thawer.Request(keys)
+	if err != nil {
+		return fmt.Errorf("failed to thaw objects: %w", err)
+	}
+	counts := thawer.Counts()
+	tracker.Printf("-   available: %d\n", counts[s3io.Available.String()])
+	tracker.Printf("-     thawing: %d\n", counts[s3io.Thawing.String()])
+
+	if pending == 0 {
+		return nil
+	}
+	if wait == false {
+		return fmt.Errorf("%d objects are still thawing; run again later to continue", pending)
+	}
+
+	tracker.Printf("Waiting for %d objects to thaw, checking every %s\n", pending, poll)
+	err = thawer.Wait(context.Background(), poll, func(pending int) {
+		tracker.Printf("- %s: %d objects still thawing\n", time.Now().Format(time.DateTime), pending)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to thaw objects: %w", err)
+	}
+	tracker.Printf("\n")
+
+	return nil
+}
@@ -0,0 +1,255 @@
+// Package cache keeps decrypted copies of the manifests and job configurations
+// on local disk so they're only downloaded once.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// DefaultSize is the size the cache is kept under if none is given.
+const DefaultSize int64 = 1024 * 1024 * 1024
+
+// Cache is a directory of object contents keyed by the object key and ETag, so a
+// changed object is never served from the cache. The directory is only accessible
+// by its owner, as the contents are decrypted. When the total size goes over the
+// limit, the least recently used entries are removed.
+type Cache struct {
+	dir  string
+	size int64
+
+	mu sync.Mutex
+}
+
+// Open opens the cache in the directory, creating it if needed. The 'default'
+// directory is '~/.s3bu/cache'.
+func Open(dir string, size int64) (*Cache, error) {
+	if dir == "default" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(u.HomeDir, ".s3bu", "cache")
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	// the contents are decrypted so no one else can have access
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("permissions on cache directory are too open: %#o", info.Mode().Perm())
+	}
+
+	c := Cache{
+		dir:  dir,
+		size: size,
+	}
+	return &c, nil
+}
+
+func (c *Cache) path(key, etag string) string {
+	h := sha256.Sum256([]byte(key + "\x00" + etag))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:]))
+}
+
+// Get opens the cached content for the key and ETag, reporting false if it isn't
+// in the cache.
+func (c *Cache) Get(key, etag string) (*os.File, bool) {
+	fpath := c.path(key, etag)
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, false
+	}
+
+	// the modification time is the last use for the eviction
+	now := time.Now()
+	os.Chtimes(fpath, now, now)
+
+	return f, true
+}
+
+// Put stores the content for the key and ETag, then evicts the least recently
+// used entries if the cache is over its size.
+func (c *Cache) Put(key, etag string, source io.Reader) error {
+	tmp, err := os.CreateTemp(c.dir, ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, source)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key, etag))
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.evict()
+}
+
+// evict removes the oldest entries until the cache is within its size.
+func (c *Cache) evict() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	var infos []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+		total += info.Size()
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	for _, info := range infos {
+		if total <= c.size {
+			break
+		}
+		err := os.Remove(filepath.Join(c.dir, info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= info.Size()
+	}
+
+	return nil
+}
+
+// client serves the downloads of the keys under the prefixes from the cache.
+type client struct {
+	s3io.Client
+
+	cache    *Cache
+	prefixes []string
+}
+
+// NewClient wraps the client so downloads of keys under the prefixes are served
+// from the cache when their ETag matches, and stored in it when they don't.
+func NewClient(cl s3io.Client, cache *Cache, prefixes ...string) s3io.Client {
+	return &client{
+		Client:   cl,
+		cache:    cache,
+		prefixes: prefixes,
+	}
+}
+
+// Clone clones the wrapped client, sharing the cache.
+func (cl *client) Clone() s3io.Client {
+	return &client{
+		Client:   cl.Client.Clone(),
+		cache:    cl.cache,
+		prefixes: cl.prefixes,
+	}
+}
+
+func (cl *client) cached(key string) bool {
+	for _, prefix := range cl.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cl *client) Download(key string, sink io.Writer) (int64, error) {
+	if !cl.cached(key) {
+		return cl.Client.Download(key, sink)
+	}
+
+	etag, err := cl.Client.ETag(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if f, ok := cl.cache.Get(key, etag); ok {
+		defer f.Close()
+		return io.Copy(sink, f)
+	}
+
+	// download to a temporary file first, so only complete downloads are cached
+	tmp, err := os.CreateTemp(cl.cache.dir, ".get-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	nbytes, err := cl.Client.Download(key, tmp)
+	if err != nil {
+		return nbytes, err
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return nbytes, err
+	}
+	err = cl.cache.Put(key, etag, tmp)
+	if err != nil {
+		return nbytes, err
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return nbytes, err
+	}
+	return io.Copy(sink, tmp)
+}
+
+// Wrap opens the cache in the directory and wraps the client to use it for the
+// manifests and job configurations. With a directory of 'none', the client is
+// returned as it is. The size is a string such as '1GB'.
+func Wrap(cl s3io.Client, dir, size string) (s3io.Client, error) {
+	if dir == "none" {
+		return cl, nil
+	}
+
+	nbytes, err := humanize.ParseBytes(size)
+	if err != nil {
+		return nil, fmt.Errorf("bad cache size: %s", size)
+	}
+
+	c, err := Open(dir, int64(nbytes))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(cl, c, "manifests/", "jobs/"), nil
+}
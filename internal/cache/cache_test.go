@@ -0,0 +1,121 @@
+package cache_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/cache"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// fakeClient serves objects from memory and counts the downloads.
+type fakeClient struct {
+	s3io.Client
+
+	data      map[string]string
+	etags     map[string]string
+	downloads map[string]int
+}
+
+func (fc *fakeClient) ETag(key string) (string, error) {
+	return fc.etags[key], nil
+}
+
+func (fc *fakeClient) Download(key string, sink io.Writer) (int64, error) {
+	fc.downloads[key]++
+	return io.Copy(sink, strings.NewReader(fc.data[key]))
+}
+
+func TestCacheGetPut(t *testing.T) {
+	c, err := cache.Open(filepath.Join(t.TempDir(), "cache"), cache.DefaultSize)
+	require.NoError(t, err)
+
+	_, ok := c.Get("manifests/a", "etag1")
+	require.False(t, ok)
+
+	require.NoError(t, c.Put("manifests/a", "etag1", strings.NewReader("content")))
+
+	f, ok := c.Get("manifests/a", "etag1")
+	require.True(t, ok)
+	data, err := io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	require.Equal(t, "content", string(data))
+
+	// a different etag is a different object
+	_, ok = c.Get("manifests/a", "etag2")
+	require.False(t, ok)
+}
+
+func TestCachePermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	require.NoError(t, os.Mkdir(dir, 0755))
+
+	_, err := cache.Open(dir, cache.DefaultSize)
+	require.Error(t, err)
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, err := cache.Open(filepath.Join(t.TempDir(), "cache"), 10)
+	require.NoError(t, err)
+
+	require.NoError(t, c.Put("a", "1", strings.NewReader("aaaa")))
+	require.NoError(t, c.Put("b", "1", strings.NewReader("bbbb")))
+
+	// using 'a' makes 'b' the least recently used; the times are only to the
+	//   resolution of the file system, so make the difference clear
+	time.Sleep(20 * time.Millisecond)
+	f, ok := c.Get("a", "1")
+	require.True(t, ok)
+	f.Close()
+
+	require.NoError(t, c.Put("c", "1", strings.NewReader("cccc")))
+
+	_, ok = c.Get("b", "1")
+	require.False(t, ok)
+	_, ok = c.Get("a", "1")
+	require.True(t, ok)
+	_, ok = c.Get("c", "1")
+	require.True(t, ok)
+}
+
+func TestCacheClient(t *testing.T) {
+	c, err := cache.Open(filepath.Join(t.TempDir(), "cache"), cache.DefaultSize)
+	require.NoError(t, err)
+
+	fc := &fakeClient{
+		data:      map[string]string{"manifests/m1": "manifest", "data/aaaa/aaaa": "data"},
+		etags:     map[string]string{"manifests/m1": "etag1", "data/aaaa/aaaa": "etag1"},
+		downloads: make(map[string]int),
+	}
+	client := cache.NewClient(fc, c, "manifests/")
+
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		_, err := client.Download("manifests/m1", &buf)
+		require.NoError(t, err)
+		require.Equal(t, "manifest", buf.String())
+
+		_, err = client.Download("data/aaaa/aaaa", &buf)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, fc.downloads["manifests/m1"])
+	require.Equal(t, 2, fc.downloads["data/aaaa/aaaa"])
+
+	// a new version of the object is downloaded again
+	fc.data["manifests/m1"] = "updated"
+	fc.etags["manifests/m1"] = "etag2"
+
+	var buf bytes.Buffer
+	_, err = client.Download("manifests/m1", &buf)
+	require.NoError(t, err)
+	require.Equal(t, "updated", buf.String())
+	require.Equal(t, 2, fc.downloads["manifests/m1"])
+}
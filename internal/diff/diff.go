@@ -0,0 +1,120 @@
+package diff
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/ops"
+)
+
+// Change is how a path differs between the old and new snapshots.
+type Change string
+
+const (
+	Added     Change = "added"
+	Removed   Change = "removed"
+	Modified  Change = "modified"
+	Metadata  Change = "metadata"
+	Unchanged Change = "unchanged"
+)
+
+// Classify works out the change for an entry from the stream comparer, where the
+// new snapshot is the first stream and the old one the reference. The entries must
+// have their hashes; a changed file with the same content only has a metadata change.
+func Classify(ei *ops.EntryInfo) Change {
+	switch ei.Status {
+	case ops.StatusNew:
+		return Added
+	case ops.StatusNotFound:
+		return Removed
+	}
+
+	ref := ei.Reference
+	if ref == nil {
+		return Unchanged
+	}
+	if ei.Hash != ref.Hash || ei.RawSize != ref.RawSize {
+		return Modified
+	}
+	if ei.ModTime != ref.ModTime || ei.Mode.Perm() != ref.Mode.Perm() {
+		return Metadata
+	}
+	return Unchanged
+}
+
+// File is the state of a path in one of the snapshots.
+type File struct {
+	Size    int64     `json:"size"`
+	Hash    string    `json:"hash,omitempty"`
+	ModTime time.Time `json:"mod_time"`
+	Mode    string    `json:"mode"`
+}
+
+// Entry is a changed path, with its state in each snapshot it's in.
+type Entry struct {
+	Path   string `json:"path"`
+	Change Change `json:"change"`
+	Old    *File  `json:"old,omitempty"`
+	New    *File  `json:"new,omitempty"`
+}
+
+func NewEntry(ei *ops.EntryInfo) *Entry {
+	e := Entry{
+		Path:   ei.RelPath,
+		Change: Classify(ei),
+	}
+
+	switch {
+	case e.Change == Added:
+		e.New = newFile(ei)
+	case e.Change == Removed:
+		e.Old = newFile(ei)
+	default:
+		e.New = newFile(ei)
+		if ei.Reference != nil {
+			e.Old = newFile(ei.Reference)
+		}
+	}
+
+	return &e
+}
+
+func newFile(ei *ops.EntryInfo) *File {
+	return &File{
+		Size:    ei.RawSize,
+		Hash:    ei.Hash,
+		ModTime: time.Unix(ei.ModTime, 0).UTC(),
+		Mode:    fmt.Sprintf("%04o", ei.Mode.Perm()),
+	}
+}
+
+// Summary counts the changes by type.
+type Summary struct {
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+	Modified int `json:"modified"`
+	Metadata int `json:"metadata"`
+
+	// the change in total size from the old to the new snapshot
+	SizeDelta int64 `json:"size_delta"`
+}
+
+func (s *Summary) Add(e *Entry) {
+	switch e.Change {
+	case Added:
+		s.Added++
+	case Removed:
+		s.Removed++
+	case Modified:
+		s.Modified++
+	case Metadata:
+		s.Metadata++
+	}
+
+	if e.New != nil {
+		s.SizeDelta += e.New.Size
+	}
+	if e.Old != nil {
+		s.SizeDelta -= e.Old.Size
+	}
+}
@@ -0,0 +1,67 @@
+package diff_test
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/diff"
+	"github.com/studio1767/s3backup/internal/ops"
+)
+
+func stream(entries ...*ops.EntryInfo) <-chan *ops.EntryInfo {
+	ch := make(chan *ops.EntryInfo, len(entries))
+	for _, ei := range entries {
+		ch <- ei
+	}
+	close(ch)
+	return ch
+}
+
+func TestDiffManifests(t *testing.T) {
+	older := stream(
+		&ops.EntryInfo{RelPath: "a.txt", Hash: "aaaa", RawSize: 1, ModTime: 100, Mode: 0644},
+		&ops.EntryInfo{RelPath: "b.txt", Hash: "bbbb", RawSize: 2, ModTime: 100, Mode: 0644},
+		&ops.EntryInfo{RelPath: "c.txt", Hash: "cccc", RawSize: 3, ModTime: 100, Mode: 0644},
+		&ops.EntryInfo{RelPath: "d.txt", Hash: "dddd", RawSize: 4, ModTime: 100, Mode: 0644},
+	)
+	newer := stream(
+		&ops.EntryInfo{RelPath: "a.txt", Hash: "aaaa", RawSize: 1, ModTime: 100, Mode: 0644},
+		&ops.EntryInfo{RelPath: "b.txt", Hash: "bbbb", RawSize: 2, ModTime: 200, Mode: 0644},
+		&ops.EntryInfo{RelPath: "c.txt", Hash: "c2c2", RawSize: 3, ModTime: 100, Mode: 0644},
+		&ops.EntryInfo{RelPath: "e.txt", Hash: "eeee", RawSize: 5, ModTime: 100, Mode: 0600},
+	)
+
+	changes := make(map[string]*diff.Entry)
+	var summary diff.Summary
+	for ei := range ops.NewStreamComparer(context.Background(), newer, older) {
+		e := diff.NewEntry(ei)
+		changes[e.Path] = e
+		summary.Add(e)
+	}
+
+	require.Equal(t, diff.Unchanged, changes["a.txt"].Change)
+	require.Equal(t, diff.Metadata, changes["b.txt"].Change)
+	require.Equal(t, diff.Modified, changes["c.txt"].Change)
+	require.Equal(t, diff.Removed, changes["d.txt"].Change)
+	require.Equal(t, diff.Added, changes["e.txt"].Change)
+
+	// the comparer keeps the hash of the newer manifest
+	require.Equal(t, "cccc", changes["c.txt"].Old.Hash)
+	require.Equal(t, "c2c2", changes["c.txt"].New.Hash)
+
+	require.Nil(t, changes["d.txt"].New)
+	require.Equal(t, int64(4), changes["d.txt"].Old.Size)
+	require.Nil(t, changes["e.txt"].Old)
+	require.Equal(t, "0600", changes["e.txt"].New.Mode)
+
+	require.Equal(t, diff.Summary{Added: 1, Removed: 1, Modified: 1, Metadata: 1, SizeDelta: 1}, summary)
+}
+
+func TestClassifyMode(t *testing.T) {
+	ref := &ops.EntryInfo{RelPath: "a.sh", Hash: "aaaa", RawSize: 1, ModTime: 100, Mode: 0644}
+	ei := &ops.EntryInfo{RelPath: "a.sh", Hash: "aaaa", RawSize: 1, ModTime: 100, Mode: 0755, Reference: ref}
+
+	require.Equal(t, diff.Metadata, diff.Classify(ei))
+}
@@ -0,0 +1,126 @@
+// Package history collects the versions of files across the snapshots of their
+// sources.
+package history
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/studio1767/s3backup/internal/manifest"
+	"github.com/studio1767/s3backup/internal/ops"
+)
+
+// Version is a distinct content of a file, with the first and last snapshots it
+// was seen in and how many snapshots it was in.
+type Version struct {
+	Hash      string             `json:"hash"`
+	Size      int64              `json:"size"`
+	ModTime   int64              `json:"mod_time"`
+	Mode      uint32             `json:"mode"`
+	First     *manifest.Snapshot `json:"first"`
+	Last      *manifest.Snapshot `json:"last"`
+	Snapshots int                `json:"snapshots"`
+}
+
+// File is the history of a path in a source.
+type File struct {
+	Job      string     `json:"job"`
+	Label    string     `json:"label"`
+	Path     string     `json:"path"`
+	Versions []*Version `json:"versions"`
+}
+
+// History gathers the file versions from the manifests. The manifests of each
+// source must be added in the order they were made.
+type History struct {
+	files map[string]*File
+}
+
+func New() *History {
+	return &History{
+		files: make(map[string]*File),
+	}
+}
+
+// Add records the entry as being in the snapshot.
+func (h *History) Add(snap *manifest.Snapshot, ei *ops.EntryInfo) {
+	id := fmt.Sprintf("%s/%s/%s", snap.Job, snap.Label, ei.RelPath)
+
+	file := h.files[id]
+	if file == nil {
+		file = &File{
+			Job:   snap.Job,
+			Label: snap.Label,
+			Path:  ei.RelPath,
+		}
+		h.files[id] = file
+	}
+
+	for _, v := range file.Versions {
+		if v.Hash == ei.Hash {
+			v.Last = snap
+			v.Snapshots++
+			return
+		}
+	}
+
+	file.Versions = append(file.Versions, &Version{
+		Hash:      ei.Hash,
+		Size:      ei.RawSize,
+		ModTime:   ei.ModTime,
+		Mode:      uint32(ei.Mode.Perm()),
+		First:     snap,
+		Last:      snap,
+		Snapshots: 1,
+	})
+}
+
+// Files returns the files found, sorted by job, label and path, with their
+// versions in the order they first appeared.
+func (h *History) Files() []*File {
+	files := make([]*File, 0, len(h.files))
+	for _, file := range h.files {
+		files = append(files, file)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.Job != b.Job {
+			return a.Job < b.Job
+		}
+		if a.Label != b.Label {
+			return a.Label < b.Label
+		}
+		return a.Path < b.Path
+	})
+
+	return files
+}
+
+// Find returns the file and version whose hash starts with the prefix. The prefix
+// must only match one version, though it can be in several files.
+func (h *History) Find(prefix string) (*File, *Version, error) {
+	var found_file *File
+	var found *Version
+
+	for _, file := range h.Files() {
+		for _, v := range file.Versions {
+			if !strings.HasPrefix(v.Hash, prefix) {
+				continue
+			}
+			if found != nil && found.Hash != v.Hash {
+				return nil, nil, fmt.Errorf("more than one version matches %s", prefix)
+			}
+			if found == nil {
+				found_file = file
+				found = v
+			}
+		}
+	}
+
+	if found == nil {
+		return nil, nil, fmt.Errorf("no version matches %s", prefix)
+	}
+	return found_file, found, nil
+}
@@ -0,0 +1,57 @@
+package history_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/history"
+	"github.com/studio1767/s3backup/internal/manifest"
+	"github.com/studio1767/s3backup/internal/ops"
+)
+
+func snapshot(t *testing.T, key string) *manifest.Snapshot {
+	snap, err := manifest.ParseKey(key)
+	require.NoError(t, err)
+	return snap
+}
+
+func TestHistory(t *testing.T) {
+	mon := snapshot(t, "manifests/job/home/job-home-2024-03-04-03600.csv.gz")
+	tue := snapshot(t, "manifests/job/home/job-home-2024-03-05-03600.csv.gz")
+	wed := snapshot(t, "manifests/job/home/job-home-2024-03-06-03600.csv.gz")
+	other := snapshot(t, "manifests/job/docs/job-docs-2024-03-06-03600.csv.gz")
+
+	h := history.New()
+	h.Add(mon, &ops.EntryInfo{RelPath: "report.xlsx", Hash: "aaaa1111", RawSize: 10})
+	h.Add(tue, &ops.EntryInfo{RelPath: "report.xlsx", Hash: "aaaa1111", RawSize: 10})
+	h.Add(wed, &ops.EntryInfo{RelPath: "report.xlsx", Hash: "bbbb2222", RawSize: 3})
+	h.Add(other, &ops.EntryInfo{RelPath: "report.xlsx", Hash: "aaaa1111", RawSize: 10})
+
+	files := h.Files()
+	require.Len(t, files, 2)
+
+	// sorted by label, so docs comes first
+	require.Equal(t, "docs", files[0].Label)
+	require.Len(t, files[0].Versions, 1)
+
+	home := files[1]
+	require.Equal(t, "home", home.Label)
+	require.Len(t, home.Versions, 2)
+	require.Equal(t, "aaaa1111", home.Versions[0].Hash)
+	require.Equal(t, mon, home.Versions[0].First)
+	require.Equal(t, tue, home.Versions[0].Last)
+	require.Equal(t, 2, home.Versions[0].Snapshots)
+	require.Equal(t, wed, home.Versions[1].First)
+
+	// the same content in two files is still one version
+	_, v, err := h.Find("aaaa")
+	require.NoError(t, err)
+	require.Equal(t, "aaaa1111", v.Hash)
+
+	_, _, err = h.Find("cccc")
+	require.Error(t, err)
+
+	h.Add(wed, &ops.EntryInfo{RelPath: "notes.txt", Hash: "aaaa3333", RawSize: 1})
+	_, _, err = h.Find("aaaa")
+	require.Error(t, err)
+}
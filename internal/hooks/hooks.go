@@ -0,0 +1,87 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/job"
+)
+
+const DefaultTimeout = time.Hour
+
+// The phases a hook can be run in; passed to the hook as S3BU_PHASE.
+const (
+	PreBackup  = "pre_backup"
+	PostBackup = "post_backup"
+)
+
+// The failure policies for pre-backup hooks.
+const (
+	PolicySkip  = "skip"
+	PolicyAbort = "abort"
+)
+
+// Env describes the backup to the hook.
+type Env struct {
+	Bucket string
+	Job    string
+	Label  string
+	Path   string
+}
+
+// Run runs the hook with the environment describing the job, label and path.
+// A nil hook does nothing.
+func Run(hook *job.Hook, phase string, env Env) error {
+	if hook == nil || hook.Command == "" {
+		return nil
+	}
+
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// a timeout kills everything the hook started, where the platform allows
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook.Command)
+	killGroup(cmd)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"S3BU_PHASE="+phase,
+		"S3BU_BUCKET="+env.Bucket,
+		"S3BU_JOB="+env.Job,
+		"S3BU_LABEL="+env.Label,
+		"S3BU_PATH="+env.Path,
+	)
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s hook timed out after %s: %s", phase, timeout, hook.Command)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %s: %w", phase, hook.Command, err)
+	}
+
+	return nil
+}
+
+// Aborts reports if a failure of the pre-backup hook should abort the job rather
+// than just skip the source.
+func Aborts(hook *job.Hook) (bool, error) {
+	if hook == nil {
+		return false, nil
+	}
+	switch hook.OnFailure {
+	case "", PolicySkip:
+		return false, nil
+	case PolicyAbort:
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown hook failure policy: %s", hook.OnFailure)
+}
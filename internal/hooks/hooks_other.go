@@ -0,0 +1,15 @@
+//go:build !unix
+
+package hooks
+
+import (
+	"os/exec"
+)
+
+// killGroup kills only the command itself when it's cancelled, as there are no
+// process groups to kill.
+func killGroup(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,59 @@
+package hooks_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/hooks"
+	"github.com/studio1767/s3backup/internal/job"
+)
+
+func TestNilHookDoesNothing(t *testing.T) {
+	require.NoError(t, hooks.Run(nil, hooks.PreBackup, hooks.Env{}))
+}
+
+func TestHookEnvironment(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "env.txt")
+	hook := job.Hook{
+		Command: fmt.Sprintf("echo $S3BU_PHASE $S3BU_JOB $S3BU_LABEL $S3BU_PATH > %s", output),
+	}
+
+	err := hooks.Run(&hook, hooks.PreBackup, hooks.Env{Job: "job", Label: "home", Path: "/home/me"})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, "pre_backup job home /home/me\n", string(data))
+}
+
+func TestHookFailure(t *testing.T) {
+	hook := job.Hook{Command: "exit 3"}
+	require.Error(t, hooks.Run(&hook, hooks.PostBackup, hooks.Env{}))
+}
+
+func TestHookTimeout(t *testing.T) {
+	hook := job.Hook{Command: "sleep 5", Timeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	err := hooks.Run(&hook, hooks.PreBackup, hooks.Env{})
+	require.ErrorContains(t, err, "timed out")
+	require.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestAbortPolicy(t *testing.T) {
+	abort, err := hooks.Aborts(&job.Hook{OnFailure: "abort"})
+	require.NoError(t, err)
+	require.True(t, abort)
+
+	abort, err = hooks.Aborts(&job.Hook{})
+	require.NoError(t, err)
+	require.False(t, abort)
+
+	_, err = hooks.Aborts(&job.Hook{OnFailure: "ignore"})
+	require.Error(t, err)
+}
@@ -0,0 +1,395 @@
+// Package index keeps a local record of the data keys in the bucket so the
+// uploads don't need a HEAD request to find out if the content is already there.
+package index
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// DefaultMaxAge is how long a listing of the bucket is trusted if no age is given.
+const DefaultMaxAge = 24 * time.Hour
+
+// retryAfter is how long to wait before trying again after a failed refresh.
+const retryAfter = time.Minute
+
+// Index is the set of data keys from the last listing of the bucket, together with
+// the keys uploaded since. It's saved in a file that starts with the time of the
+// listing, followed by a key per line; keys that were deleted have a '-' prefix.
+// Keys added or removed are appended to the file as they happen.
+type Index struct {
+	path    string
+	max_age time.Duration
+
+	mu         sync.Mutex
+	keys       map[string]struct{}
+	refreshed  time.Time
+	refreshing bool
+	attempted  time.Time
+	recent     map[string]bool
+}
+
+// Open loads the index from the file, or starts an empty one if there isn't a
+// file yet. The index is stale once its listing is older than the max age.
+func Open(path string, max_age time.Duration) (*Index, error) {
+	idx := Index{
+		path:    path,
+		max_age: max_age,
+		keys:    make(map[string]struct{}),
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = idx.load(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index %s: %w", path, err)
+	}
+	return &idx, nil
+}
+
+func (idx *Index) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+
+	refreshed, err := time.Parse(time.RFC3339, scanner.Text())
+	if err != nil {
+		return err
+	}
+	idx.refreshed = refreshed
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if key, ok := strings.CutPrefix(line, "-"); ok {
+			delete(idx.keys, key)
+		} else if line != "" {
+			idx.keys[line] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// Has reports if the key is known to be in the bucket.
+func (idx *Index) Has(key string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	_, ok := idx.keys[key]
+	return ok
+}
+
+// Len returns the number of keys in the index.
+func (idx *Index) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return len(idx.keys)
+}
+
+// Fresh reports if the listing is recent enough that a key missing from the index
+// can be taken to be missing from the bucket.
+func (idx *Index) Fresh() bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return idx.fresh()
+}
+
+func (idx *Index) fresh() bool {
+	return !idx.refreshed.IsZero() && time.Since(idx.refreshed) < idx.max_age
+}
+
+// Add records the key as being in the bucket.
+func (idx *Index) Add(key string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.keys[key]; ok {
+		return nil
+	}
+	idx.keys[key] = struct{}{}
+	if idx.recent != nil {
+		idx.recent[key] = true
+	}
+	return idx.append(key)
+}
+
+// Remove records the key as no longer being in the bucket.
+func (idx *Index) Remove(key string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.keys[key]; !ok {
+		return nil
+	}
+	delete(idx.keys, key)
+	if idx.recent != nil {
+		idx.recent[key] = false
+	}
+	return idx.append("-" + key)
+}
+
+// append adds the line to the file; with no listing yet there's no file, and the
+// change is kept until the first one is saved.
+func (idx *Index) append(line string) error {
+	if idx.refreshed.IsZero() {
+		return nil
+	}
+
+	f, err := os.OpenFile(idx.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Refresh replaces the index with a listing of the data keys in the bucket. Keys
+// added or removed while the listing is running are kept.
+func (idx *Index) Refresh(cl s3io.Client) error {
+	idx.mu.Lock()
+	idx.refreshing = true
+	idx.attempted = time.Now()
+	idx.recent = make(map[string]bool)
+	idx.mu.Unlock()
+
+	defer func() {
+		idx.mu.Lock()
+		idx.refreshing = false
+		idx.recent = nil
+		idx.mu.Unlock()
+	}()
+
+	started := time.Now()
+	keys, err := cl.ListMatching("data/")
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	listed := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		listed[key] = struct{}{}
+	}
+	for key, added := range idx.recent {
+		if added {
+			listed[key] = struct{}{}
+		} else {
+			delete(listed, key)
+		}
+	}
+
+	err = idx.save(listed, started)
+	if err != nil {
+		return err
+	}
+	idx.keys = listed
+	idx.refreshed = started
+	return nil
+}
+
+// startRefresh refreshes the index in the background if it's stale and there
+// isn't a refresh running or recently failed.
+func (idx *Index) startRefresh(cl s3io.Client) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.fresh() || idx.refreshing || time.Since(idx.attempted) < retryAfter {
+		return
+	}
+	idx.refreshing = true
+
+	go idx.Refresh(cl)
+}
+
+// save writes the keys to a temporary file and moves it into place.
+func (idx *Index) save(keys map[string]struct{}, refreshed time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(idx.path), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintln(w, refreshed.UTC().Format(time.RFC3339))
+	for key := range keys {
+		fmt.Fprintln(w, key)
+	}
+	err = w.Flush()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), idx.path)
+}
+
+// client answers Exists for data keys from the index, and keeps the index up to
+// date with the uploads.
+type client struct {
+	s3io.Client
+
+	index *Index
+}
+
+// NewClient wraps the client so the index is consulted before checking the bucket
+// for a data key. While the index is fresh, a key in it exists and a key missing
+// from it doesn't. When the index is stale, it's refreshed in the background and
+// the bucket is checked for every key until the refresh is done, so objects
+// removed since the last listing are uploaded again.
+func NewClient(cl s3io.Client, index *Index) s3io.Client {
+	return &client{
+		Client: cl,
+		index:  index,
+	}
+}
+
+// Clone clones the wrapped client, sharing the index.
+func (cl *client) Clone() s3io.Client {
+	return &client{
+		Client: cl.Client.Clone(),
+		index:  cl.index,
+	}
+}
+
+func isData(key string) bool {
+	return strings.HasPrefix(key, "data/")
+}
+
+func (cl *client) Exists(key string) (bool, error) {
+	if !isData(key) {
+		return cl.Client.Exists(key)
+	}
+
+	if cl.index.Fresh() {
+		return cl.index.Has(key), nil
+	}
+
+	cl.index.startRefresh(cl.Client)
+
+	exists, err := cl.Client.Exists(key)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		cl.index.Add(key)
+	} else {
+		cl.index.Remove(key)
+	}
+	return exists, nil
+}
+
+func (cl *client) uploaded(key string, nbytes int64, err error) (int64, error) {
+	if err == nil && isData(key) {
+		cl.index.Add(key)
+	}
+	return nbytes, err
+}
+
+func (cl *client) Upload(key string, source io.Reader) (int64, error) {
+	nbytes, err := cl.Client.Upload(key, source)
+	return cl.uploaded(key, nbytes, err)
+}
+
+func (cl *client) UploadCompressed(key string, source io.Reader) (int64, error) {
+	nbytes, err := cl.Client.UploadCompressed(key, source)
+	return cl.uploaded(key, nbytes, err)
+}
+
+func (cl *client) UploadEncrypted(key string, source io.Reader, compress bool) (int64, error) {
+	nbytes, err := cl.Client.UploadEncrypted(key, source, compress)
+	return cl.uploaded(key, nbytes, err)
+}
+
+func (cl *client) UploadPassphrase(key string, source io.Reader, compress bool) (int64, error) {
+	nbytes, err := cl.Client.UploadPassphrase(key, source, compress)
+	return cl.uploaded(key, nbytes, err)
+}
+
+func (cl *client) Move(src, dst string) error {
+	err := cl.Client.Move(src, dst)
+	if err != nil {
+		return err
+	}
+	if isData(src) {
+		cl.index.Remove(src)
+	}
+	if isData(dst) {
+		cl.index.Add(dst)
+	}
+	return nil
+}
+
+func (cl *client) Delete(key string) error {
+	err := cl.Client.Delete(key)
+	if err == nil && isData(key) {
+		cl.index.Remove(key)
+	}
+	return err
+}
+
+// Name returns the name of the index file for the bucket. It's made from the
+// endpoint, profile and bucket, and the time the repository was created, so buckets
+// with the same name on different services, or a recreated bucket, have their own
+// index.
+func Name(endpoint, profile, bucket string, created time.Time) string {
+	id := fmt.Sprintf("%s\x00%s\x00%s\x00%s", endpoint, profile, bucket, created.UTC().Format(time.RFC3339))
+	h := sha256.Sum256([]byte(id))
+	return fmt.Sprintf("%s-%s", bucket, hex.EncodeToString(h[:8]))
+}
+
+// Wrap opens the index for the bucket in the directory and wraps the client to use
+// it. The 'default' directory is '~/.s3bu/index'; with 'none', the client is
+// returned as it is.
+func Wrap(cl s3io.Client, profile, bucket, dir string, max_age time.Duration) (s3io.Client, error) {
+	if dir == "none" {
+		return cl, nil
+	}
+
+	if dir == "default" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(u.HomeDir, ".s3bu", "index")
+	}
+
+	name := Name(cl.Endpoint(), profile, bucket, cl.RepoConfig().Created)
+	idx, err := Open(filepath.Join(dir, name), max_age)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(cl, idx), nil
+}
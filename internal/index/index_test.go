@@ -0,0 +1,206 @@
+package index_test
+
+import (
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/index"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// fakeClient keeps the keys in memory and counts the HEAD requests.
+type fakeClient struct {
+	s3io.Client
+
+	mu    sync.Mutex
+	keys  map[string]bool
+	heads int
+}
+
+func newFakeClient(keys ...string) *fakeClient {
+	fc := fakeClient{
+		keys: make(map[string]bool),
+	}
+	for _, key := range keys {
+		fc.keys[key] = true
+	}
+	return &fc
+}
+
+func (fc *fakeClient) Exists(key string) (bool, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.heads++
+	return fc.keys[key], nil
+}
+
+func (fc *fakeClient) ListMatching(prefix string) ([]string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	var keys []string
+	for key := range fc.keys {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (fc *fakeClient) UploadEncrypted(key string, source io.Reader, compress bool) (int64, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.keys[key] = true
+	return io.Copy(io.Discard, source)
+}
+
+func (fc *fakeClient) Move(src, dst string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	delete(fc.keys, src)
+	fc.keys[dst] = true
+	return nil
+}
+
+func (fc *fakeClient) headCount() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.heads
+}
+
+func TestIndexRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index", "bucket")
+	fc := newFakeClient("data/aaaa/aaaa1", "data/bbbb/bbbb2", "jobs/test.yml")
+
+	idx, err := index.Open(path, time.Hour)
+	require.NoError(t, err)
+	require.False(t, idx.Fresh())
+
+	require.NoError(t, idx.Refresh(fc))
+	require.True(t, idx.Fresh())
+	require.Equal(t, 2, idx.Len())
+	require.True(t, idx.Has("data/aaaa/aaaa1"))
+	require.False(t, idx.Has("jobs/test.yml"))
+
+	// changes are kept in the file
+	require.NoError(t, idx.Add("data/cccc/cccc3"))
+	require.NoError(t, idx.Remove("data/aaaa/aaaa1"))
+
+	idx, err = index.Open(path, time.Hour)
+	require.NoError(t, err)
+	require.True(t, idx.Fresh())
+	require.Equal(t, 2, idx.Len())
+	require.False(t, idx.Has("data/aaaa/aaaa1"))
+	require.True(t, idx.Has("data/cccc/cccc3"))
+
+	// an old listing is stale
+	idx, err = index.Open(path, time.Nanosecond)
+	require.NoError(t, err)
+	require.False(t, idx.Fresh())
+	require.True(t, idx.Has("data/cccc/cccc3"))
+}
+
+func TestClientExists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucket")
+	fc := newFakeClient("data/aaaa/aaaa1")
+
+	idx, err := index.Open(path, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, idx.Refresh(fc))
+	cl := index.NewClient(fc, idx)
+
+	// with a fresh index, data keys don't need the bucket
+	exists, err := cl.Exists("data/aaaa/aaaa1")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = cl.Exists("data/bbbb/bbbb2")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Equal(t, 0, fc.headCount())
+
+	// other keys always go to the bucket
+	_, err = cl.Exists("jobs/test.yml")
+	require.NoError(t, err)
+	require.Equal(t, 1, fc.headCount())
+
+	// uploads and moves are added
+	_, err = cl.UploadEncrypted("data/bbbb/bbbb2", strings.NewReader("content"), false)
+	require.NoError(t, err)
+	require.True(t, idx.Has("data/bbbb/bbbb2"))
+
+	require.NoError(t, cl.Move("tmp/cccc3", "data/cccc/cccc3"))
+	require.True(t, idx.Has("data/cccc/cccc3"))
+	require.False(t, idx.Has("tmp/cccc3"))
+}
+
+func TestClientStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucket")
+	fc := newFakeClient("data/aaaa/aaaa1", "data/bbbb/bbbb2")
+
+	idx, err := index.Open(path, time.Hour)
+	require.NoError(t, err)
+	cl := index.NewClient(fc, idx)
+
+	// without a listing, the bucket is checked while the index is built
+	exists, err := cl.Exists("data/aaaa/aaaa1")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, 1, fc.headCount())
+
+	require.Eventually(t, idx.Fresh, time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, idx.Len())
+
+	exists, err = cl.Exists("data/bbbb/bbbb2")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, 1, fc.headCount())
+}
+
+func TestClientStaleHits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucket")
+	fc := newFakeClient("data/aaaa/aaaa1", "data/bbbb/bbbb2")
+
+	idx, err := index.Open(path, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, idx.Refresh(fc))
+
+	// the object is removed from the bucket, by a lifecycle rule say, and the
+	//   index goes stale
+	delete(fc.keys, "data/aaaa/aaaa1")
+	idx, err = index.Open(path, time.Nanosecond)
+	require.NoError(t, err)
+	require.True(t, idx.Has("data/aaaa/aaaa1"))
+	cl := index.NewClient(fc, idx)
+
+	// the hit is checked with the bucket rather than trusted
+	exists, err := cl.Exists("data/aaaa/aaaa1")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Equal(t, 1, fc.headCount())
+	require.False(t, idx.Has("data/aaaa/aaaa1"))
+}
+
+func TestName(t *testing.T) {
+	created := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	name := index.Name("https://s3.us-east-1.amazonaws.com", "default", "bucket", created)
+	require.True(t, strings.HasPrefix(name, "bucket-"))
+
+	// the same bucket name elsewhere, or recreated, is a different index
+	require.NotEqual(t, name, index.Name("http://localhost:9000", "default", "bucket", created))
+	require.NotEqual(t, name, index.Name("https://s3.us-east-1.amazonaws.com", "minio", "bucket", created))
+	require.NotEqual(t, name, index.Name("https://s3.us-east-1.amazonaws.com", "default", "bucket", created.Add(time.Hour)))
+	require.Equal(t, name, index.Name("https://s3.us-east-1.amazonaws.com", "default", "bucket", created))
+}
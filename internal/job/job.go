@@ -7,6 +7,8 @@ import (
 	"io"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v3"
 
@@ -21,13 +23,54 @@ func (e *ErrNoSuchJob) Error() string {
 	return e.msg
 }
 
+// Notification is a target to notify when a backup run completes. Exactly one
+// of Webhook or Command should be set. The webhook receives the run report as
+// a json POST; the command is run with the shell and receives the report on stdin.
+// On is one of 'success', 'failure' or 'always', defaulting to 'always'.
+type Notification struct {
+	Webhook string
+	Command string
+	On      string
+	Timeout time.Duration
+}
+
+// Hook is a command run with the shell before or after a backup. If the
+// command doesn't complete within the timeout it is killed and treated as
+// failed. OnFailure is the policy for a failing pre-backup hook: 'skip' to skip
+// the source or 'abort' to abort the job.
+type Hook struct {
+	Command   string
+	Timeout   time.Duration
+	OnFailure string `yaml:"on_failure"`
+}
+
+// Source is a directory to backup, or a command whose output is backed up. For
+// a command source, Command is run with the shell and its stdout is stored as a
+// file called Name; Path is used as the working directory if it's set.
+type Source struct {
+	Path  string
+	Label string
+
+	Command string
+	Name    string
+
+	// cron expression for daemon mode; overrides the job schedule
+	Schedule string
+
+	PreBackup  *Hook `yaml:"pre_backup"`
+	PostBackup *Hook `yaml:"post_backup"`
+}
+
 type Job struct {
 	Name string
 
-	Sources []struct {
-		Path  string
-		Label string
-	}
+	Sources []Source
+
+	// cron expression for daemon mode
+	Schedule string
+
+	PreBackup  *Hook `yaml:"pre_backup"`
+	PostBackup *Hook `yaml:"post_backup"`
 
 	IncludeTopDirs []string `yaml:"include_top_dirs"`
 	ExcludeTopDirs []string `yaml:"exclude_top_dirs"`
@@ -37,6 +80,59 @@ type Job struct {
 
 	SkipDirs     []string `yaml:"skip_dirs"`
 	SkipDirItems []string `yaml:"skip_dir_items"`
+
+	// bandwidth limits, e.g. '2MB@08:00-18:00'; see s3io.ParseRateLimit
+	UploadLimit   string `yaml:"upload_limit"`
+	DownloadLimit string `yaml:"download_limit"`
+
+	// storage classes, e.g. 'STANDARD_IA', for the file data and for the
+	// manifests and job configurations; the bucket default if not set
+	StorageClass         string `yaml:"storage_class"`
+	MetadataStorageClass string `yaml:"metadata_storage_class"`
+
+	// tags for the uploaded objects; '{job}' and '{label}' in the values are
+	// replaced with the job name and source label
+	Tags map[string]string
+
+	Notifications []Notification
+}
+
+// UploadPolicies returns the upload policies for the data and for the metadata of
+// the source with the label. The label is empty for the job configuration itself.
+//
+// Data is shared between all the jobs and labels that have the same content, so
+// the tags on a data object are those of the source that first uploaded it.
+func (j *Job) UploadPolicies(label string) (*s3io.UploadPolicy, *s3io.UploadPolicy, error) {
+	tags := make(map[string]string)
+	for k, v := range j.Tags {
+		v = strings.ReplaceAll(v, "{job}", j.Name)
+		v = strings.ReplaceAll(v, "{label}", label)
+		tags[k] = v
+	}
+
+	data := s3io.UploadPolicy{
+		StorageClass: j.StorageClass,
+		Tags:         tags,
+	}
+	err := data.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the manifests and jobs must be readable without thawing
+	metadata := s3io.UploadPolicy{
+		StorageClass: j.MetadataStorageClass,
+		Tags:         tags,
+	}
+	err = metadata.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+	if s3io.IsArchiveClass(metadata.StorageClass) {
+		return nil, nil, fmt.Errorf("metadata can't be stored in an archive storage class: %s", metadata.StorageClass)
+	}
+
+	return &data, &metadata, nil
 }
 
 func Download(client s3io.Client, jobname string) (*Job, string, error) {
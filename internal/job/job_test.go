@@ -31,3 +31,32 @@ func TestJob(t *testing.T) {
 	fmt.Printf("job: %s/%s\n", bucket, jobkey)
 	fmt.Println(job)
 }
+
+func TestUploadPolicies(t *testing.T) {
+	jb := job.Job{
+		Name:                 "home",
+		StorageClass:         "standard_ia",
+		MetadataStorageClass: "",
+		Tags: map[string]string{
+			"backup": "{job}/{label}",
+			"team":   "media",
+		},
+	}
+
+	data, metadata, err := jb.UploadPolicies("docs")
+	require.NoError(t, err)
+	require.Equal(t, "STANDARD_IA", data.StorageClass)
+	require.Equal(t, "", metadata.StorageClass)
+	require.Equal(t, map[string]string{"backup": "home/docs", "team": "media"}, data.Tags)
+	require.Equal(t, data.Tags, metadata.Tags)
+
+	// manifests have to be downloadable without thawing
+	jb.MetadataStorageClass = "DEEP_ARCHIVE"
+	_, _, err = jb.UploadPolicies("docs")
+	require.Error(t, err)
+
+	jb.MetadataStorageClass = ""
+	jb.StorageClass = "CHEAP"
+	_, _, err = jb.UploadPolicies("docs")
+	require.Error(t, err)
+}
@@ -0,0 +1,554 @@
+// Package lock keeps runs that would conflict from working on the bucket at the
+// same time, using lock objects stored under the 'locks/' prefix.
+//
+// A scope, such as the whole repository or a job's source, can be held by one
+// exclusive lock or any number of shared locks. The exclusive lock is the object
+// 'locks/<scope>/exclusive', written with a conditional request so only one client
+// can create it. Each shared lock is its own object, 'locks/<scope>/shared-<id>'.
+// After writing its lock, a client checks for the other kind, so of two clients
+// racing for conflicting locks at least one sees the other and backs off.
+//
+// Locks expire unless they're refreshed, so the locks of a client that dies are
+// taken over once they expire. A refresh only replaces the object this client
+// wrote, so a lock that expired and was removed or taken over is reported as lost.
+// Buckets that don't support conditional requests fall back to checking before
+// writing, which narrows the race without closing it.
+//
+// Lock objects are written directly, without waiting on the upload limit.
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// Mode is whether the lock can be shared with other holders.
+type Mode string
+
+const (
+	Shared    Mode = "shared"
+	Exclusive Mode = "exclusive"
+)
+
+// DefaultTTL is how long a lock lasts without being refreshed.
+const DefaultTTL = 10 * time.Minute
+
+// retryInterval is how long to wait between attempts when waiting for a lock.
+const retryInterval = 5 * time.Second
+
+// RepoScope is the scope of the whole repository.
+const RepoScope = "repo"
+
+// SourceScope returns the scope of a job's source.
+func SourceScope(jobname, label string) string {
+	return fmt.Sprintf("sources/%s/%s", jobname, label)
+}
+
+// Info is the content of a lock object.
+type Info struct {
+	ID        string    `json:"id"`
+	Mode      Mode      `json:"mode"`
+	Holder    string    `json:"holder"`
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	Operation string    `json:"operation"`
+	Acquired  time.Time `json:"acquired"`
+	Expires   time.Time `json:"expires"`
+}
+
+func (info *Info) expired(now time.Time) bool {
+	return now.After(info.Expires)
+}
+
+func (info *Info) String() string {
+	return fmt.Sprintf("%s@%s (%s, pid %d) until %s", info.Holder, info.Host, info.Operation, info.PID, info.Expires.Local().Format(time.DateTime))
+}
+
+// ErrLocked is returned when the scope is held by a conflicting lock.
+type ErrLocked struct {
+	scope  string
+	holder *Info
+}
+
+func (e *ErrLocked) Error() string {
+	return fmt.Sprintf("%s is locked by %s", e.scope, e.holder)
+}
+
+// ErrLost is returned when a lock expired and was taken by another client before
+// it could be refreshed.
+type ErrLost struct {
+	scope string
+}
+
+func (e *ErrLost) Error() string {
+	return fmt.Sprintf("lock on %s was lost", e.scope)
+}
+
+// Locker takes locks for an operation, such as 's3backup', on the bucket.
+type Locker struct {
+	client    s3io.Client
+	operation string
+	ttl       time.Duration
+	wait      time.Duration
+
+	holder string
+	host   string
+	now    func() time.Time
+}
+
+// NewLocker creates a locker whose locks last for the ttl between refreshes. When
+// a lock is held by someone else, it waits up to the wait time for it.
+func NewLocker(client s3io.Client, operation string, ttl, wait time.Duration) *Locker {
+	lk := Locker{
+		client:    client,
+		operation: operation,
+		ttl:       ttl,
+		wait:      wait,
+		holder:    "unknown",
+		host:      "unknown",
+		now:       time.Now,
+	}
+
+	if u, err := user.Current(); err == nil {
+		lk.holder = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		lk.host = host
+	}
+
+	return &lk
+}
+
+func (lk *Locker) newInfo(mode Mode) (*Info, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := lk.now()
+	info := Info{
+		ID:        hex.EncodeToString(id),
+		Mode:      mode,
+		Holder:    lk.holder,
+		Host:      lk.host,
+		PID:       os.Getpid(),
+		Operation: lk.operation,
+		Acquired:  now.UTC(),
+		Expires:   now.Add(lk.ttl).UTC(),
+	}
+	return &info, nil
+}
+
+// Acquire takes a lock on the scope, retrying until the wait time is up while the
+// scope is locked by someone else. The lock is refreshed in the background until
+// it's released.
+func (lk *Locker) Acquire(scope string, mode Mode) (*Lock, error) {
+	deadline := lk.now().Add(lk.wait)
+
+	for {
+		l, err := lk.TryAcquire(scope, mode)
+		if err == nil {
+			l.start()
+			return l, nil
+		}
+
+		var locked *ErrLocked
+		if !errors.As(err, &locked) || !lk.now().Add(retryInterval).Before(deadline) {
+			return nil, err
+		}
+		time.Sleep(retryInterval)
+	}
+}
+
+// TryAcquire makes one attempt to take the lock, without refreshing it.
+func (lk *Locker) TryAcquire(scope string, mode Mode) (*Lock, error) {
+	info, err := lk.newInfo(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	l := Lock{
+		locker: lk,
+		scope:  scope,
+		info:   info,
+	}
+
+	if mode == Exclusive {
+		err = l.acquireExclusive()
+	} else {
+		err = l.acquireShared()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// read returns the lock at the key, or nil if there isn't one.
+func (lk *Locker) read(key string) (*Info, string, error) {
+	data, etag, err := lk.client.Read(key)
+	if err != nil {
+		var nosuch *s3io.ErrNoSuchObject
+		if errors.As(err, &nosuch) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+
+	var info Info
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return nil, "", fmt.Errorf("bad lock %s: %w", key, err)
+	}
+	return &info, etag, nil
+}
+
+// List returns the locks held on the scope, including expired ones.
+func (lk *Locker) List(scope string) ([]*Info, error) {
+	keys, err := lk.client.ListMatching(fmt.Sprintf("locks/%s/", scope))
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*Info
+	for _, key := range keys {
+		if path.Dir(key) != "locks/"+scope {
+			continue
+		}
+		info, _, err := lk.read(key)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// Lock is a lock held on a scope.
+type Lock struct {
+	locker *Locker
+	scope  string
+	info   *Info
+	key    string
+	etag   string
+
+	mu   sync.Mutex
+	err  error
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (l *Lock) exclusiveKey() string {
+	return fmt.Sprintf("locks/%s/exclusive", l.scope)
+}
+
+func (l *Lock) sharedPrefix() string {
+	return fmt.Sprintf("locks/%s/shared-", l.scope)
+}
+
+func (l *Lock) locked(holder *Info) error {
+	return &ErrLocked{
+		scope:  l.scope,
+		holder: holder,
+	}
+}
+
+func (l *Lock) acquireExclusive() error {
+	lk := l.locker
+	l.key = l.exclusiveKey()
+
+	data, err := json.Marshal(l.info)
+	if err != nil {
+		return err
+	}
+
+	// create the exclusive lock, taking it over if it has expired
+	for attempt := 1; ; attempt++ {
+		l.etag, err = lk.client.PutIfAbsent(l.key, data)
+		if err == nil {
+			break
+		}
+
+		var unsupported *s3io.ErrConditionNotSupported
+		if errors.As(err, &unsupported) {
+			err = l.putUnconditional(data)
+			if err != nil {
+				return err
+			}
+			break
+		}
+
+		var failed *s3io.ErrPreconditionFailed
+		if !errors.As(err, &failed) || attempt == 3 {
+			return err
+		}
+
+		holder, etag, err := lk.read(l.key)
+		if err != nil {
+			return err
+		}
+		if holder != nil && !holder.expired(lk.now()) {
+			return l.locked(holder)
+		}
+		if holder != nil {
+			err = lk.client.DeleteIfMatch(l.key, etag)
+			if err != nil && !errors.As(err, &failed) {
+				return err
+			}
+		}
+	}
+
+	// then make sure there aren't any shared locks
+	holder, err := l.sharedHolder()
+	if err == nil && holder != nil {
+		err = l.locked(holder)
+	}
+	if err != nil {
+		l.remove()
+		return err
+	}
+	return nil
+}
+
+// putUnconditional writes the exclusive lock on a bucket without conditional
+// requests, checking for a current lock first and reading it back after.
+func (l *Lock) putUnconditional(data []byte) error {
+	lk := l.locker
+
+	holder, _, err := lk.read(l.key)
+	if err != nil {
+		return err
+	}
+	if holder != nil && !holder.expired(lk.now()) {
+		return l.locked(holder)
+	}
+
+	_, err = lk.client.Put(l.key, data)
+	if err != nil {
+		return err
+	}
+
+	holder, l.etag, err = lk.read(l.key)
+	if err != nil {
+		return err
+	}
+	if holder == nil || holder.ID != l.info.ID {
+		return l.locked(holder)
+	}
+	return nil
+}
+
+// sharedHolder returns the holder of an unexpired shared lock on the scope, removing
+// any expired ones.
+func (l *Lock) sharedHolder() (*Info, error) {
+	lk := l.locker
+
+	keys, err := lk.client.ListMatching(l.sharedPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		holder, _, err := lk.read(key)
+		if err != nil {
+			return nil, err
+		}
+		if holder == nil {
+			continue
+		}
+		if holder.expired(lk.now()) {
+			lk.client.Delete(key)
+			continue
+		}
+		return holder, nil
+	}
+	return nil, nil
+}
+
+// exclusiveHolder returns the holder of an unexpired exclusive lock on the scope.
+func (l *Lock) exclusiveHolder() (*Info, error) {
+	lk := l.locker
+
+	holder, _, err := lk.read(l.exclusiveKey())
+	if err != nil || holder == nil || holder.expired(lk.now()) {
+		return nil, err
+	}
+	return holder, nil
+}
+
+func (l *Lock) acquireShared() error {
+	lk := l.locker
+	l.key = l.sharedPrefix() + l.info.ID
+
+	holder, err := l.exclusiveHolder()
+	if err != nil {
+		return err
+	}
+	if holder != nil {
+		return l.locked(holder)
+	}
+
+	data, err := json.Marshal(l.info)
+	if err != nil {
+		return err
+	}
+	l.etag, err = lk.client.Put(l.key, data)
+	if err != nil {
+		return err
+	}
+
+	// an exclusive lock taken while writing this one wins
+	holder, err = l.exclusiveHolder()
+	if err == nil && holder != nil {
+		err = l.locked(holder)
+	}
+	if err != nil {
+		l.remove()
+		return err
+	}
+	return nil
+}
+
+// Info returns the content of the lock.
+func (l *Lock) Info() *Info {
+	return l.info
+}
+
+// Refresh extends the lock by the ttl. It fails with ErrLost if the lock has been
+// taken by someone else.
+func (l *Lock) Refresh() error {
+	lk := l.locker
+
+	info := *l.info
+	info.Expires = lk.now().Add(lk.ttl).UTC()
+	data, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+
+	// a shared lock that expired may have been cleaned up and an exclusive lock
+	//   taken, so it's only extended if it's still the one written
+	etag, err := lk.client.PutIfMatch(l.key, data, l.etag)
+	var unsupported *s3io.ErrConditionNotSupported
+	if errors.As(err, &unsupported) {
+		var holder *Info
+		holder, _, err = lk.read(l.key)
+		if err == nil && (holder == nil || holder.ID != l.info.ID) {
+			return &ErrLost{scope: l.scope}
+		}
+		if err == nil {
+			_, err = lk.client.Put(l.key, data)
+		}
+		if err == nil {
+			_, etag, err = lk.read(l.key)
+		}
+	}
+	if err != nil {
+		var failed *s3io.ErrPreconditionFailed
+		var nosuch *s3io.ErrNoSuchObject
+		if errors.As(err, &failed) || errors.As(err, &nosuch) {
+			return &ErrLost{scope: l.scope}
+		}
+		return err
+	}
+
+	l.etag = etag
+	l.info = &info
+	return nil
+}
+
+// start refreshes the lock in the background at a third of the ttl.
+func (l *Lock) start() {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+
+	go func() {
+		defer close(l.done)
+
+		ticker := time.NewTicker(l.locker.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				err := l.Refresh()
+				if err != nil {
+					l.mu.Lock()
+					l.err = err
+					l.mu.Unlock()
+
+					var lost *ErrLost
+					if errors.As(err, &lost) {
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Err returns the error from the last refresh, if it failed.
+func (l *Lock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+// remove deletes the lock object, as long as it's still this lock.
+func (l *Lock) remove() error {
+	lk := l.locker
+
+	if l.etag == "" {
+		return lk.client.Delete(l.key)
+	}
+
+	err := lk.client.DeleteIfMatch(l.key, l.etag)
+	var unsupported *s3io.ErrConditionNotSupported
+	if errors.As(err, &unsupported) {
+		holder, _, err := lk.read(l.key)
+		if err != nil || holder == nil || holder.ID != l.info.ID {
+			return err
+		}
+		return lk.client.Delete(l.key)
+	}
+
+	var failed *s3io.ErrPreconditionFailed
+	var nosuch *s3io.ErrNoSuchObject
+	if errors.As(err, &failed) || errors.As(err, &nosuch) {
+		return nil
+	}
+	return err
+}
+
+// Release stops refreshing the lock and removes it. If the lock was lost while it
+// was held, that's returned as the error.
+func (l *Lock) Release() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+
+	err := l.remove()
+
+	var lost *ErrLost
+	if lerr := l.Err(); errors.As(lerr, &lost) {
+		return lerr
+	}
+	return err
+}
@@ -0,0 +1,256 @@
+package lock_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/lock"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// fakeClient keeps the objects in memory, with or without support for conditional
+// requests.
+type fakeClient struct {
+	s3io.Client
+
+	mu          sync.Mutex
+	objects     map[string][]byte
+	conditional bool
+}
+
+func newFakeClient(conditional bool) *fakeClient {
+	return &fakeClient{
+		objects:     make(map[string][]byte),
+		conditional: conditional,
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (fc *fakeClient) Put(key string, data []byte) (string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.objects[key] = data
+	return etag(data), nil
+}
+
+func (fc *fakeClient) PutIfAbsent(key string, data []byte) (string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if !fc.conditional {
+		return "", &s3io.ErrConditionNotSupported{}
+	}
+	if _, ok := fc.objects[key]; ok {
+		return "", &s3io.ErrPreconditionFailed{}
+	}
+	fc.objects[key] = data
+	return etag(data), nil
+}
+
+func (fc *fakeClient) PutIfMatch(key string, data []byte, tag string) (string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if !fc.conditional {
+		return "", &s3io.ErrConditionNotSupported{}
+	}
+	old, ok := fc.objects[key]
+	if !ok || etag(old) != tag {
+		return "", &s3io.ErrPreconditionFailed{}
+	}
+	fc.objects[key] = data
+	return etag(data), nil
+}
+
+func (fc *fakeClient) Read(key string) ([]byte, string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	data, ok := fc.objects[key]
+	if !ok {
+		return nil, "", &s3io.ErrNoSuchObject{}
+	}
+	return data, etag(data), nil
+}
+
+func (fc *fakeClient) Delete(key string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	delete(fc.objects, key)
+	return nil
+}
+
+func (fc *fakeClient) DeleteIfMatch(key, tag string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if !fc.conditional {
+		return &s3io.ErrConditionNotSupported{}
+	}
+	old, ok := fc.objects[key]
+	if !ok {
+		return &s3io.ErrNoSuchObject{}
+	}
+	if etag(old) != tag {
+		return &s3io.ErrPreconditionFailed{}
+	}
+	delete(fc.objects, key)
+	return nil
+}
+
+func (fc *fakeClient) ListMatching(prefix string) ([]string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	var keys []string
+	for key := range fc.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestExclusive(t *testing.T) {
+	for _, conditional := range []bool{true, false} {
+		fc := newFakeClient(conditional)
+		lk := lock.NewLocker(fc, "test", time.Minute, 0)
+
+		l, err := lk.Acquire(lock.RepoScope, lock.Exclusive)
+		require.NoError(t, err)
+
+		// nothing else can take the scope
+		_, err = lk.TryAcquire(lock.RepoScope, lock.Exclusive)
+		var locked *lock.ErrLocked
+		require.ErrorAs(t, err, &locked)
+
+		_, err = lk.TryAcquire(lock.RepoScope, lock.Shared)
+		require.ErrorAs(t, err, &locked)
+
+		// other scopes are free
+		other, err := lk.TryAcquire(lock.SourceScope("job", "label"), lock.Exclusive)
+		require.NoError(t, err)
+		require.NoError(t, other.Release())
+
+		require.NoError(t, l.Refresh())
+		require.NoError(t, l.Release())
+
+		l, err = lk.TryAcquire(lock.RepoScope, lock.Exclusive)
+		require.NoError(t, err)
+		require.NoError(t, l.Release())
+		require.Empty(t, fc.objects)
+	}
+}
+
+func TestShared(t *testing.T) {
+	fc := newFakeClient(true)
+	lk := lock.NewLocker(fc, "test", time.Minute, 0)
+
+	l1, err := lk.TryAcquire(lock.RepoScope, lock.Shared)
+	require.NoError(t, err)
+	l2, err := lk.TryAcquire(lock.RepoScope, lock.Shared)
+	require.NoError(t, err)
+
+	infos, err := lk.List(lock.RepoScope)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+
+	// an exclusive lock has to wait for the shared ones, and doesn't leave its
+	//   lock behind
+	_, err = lk.TryAcquire(lock.RepoScope, lock.Exclusive)
+	var locked *lock.ErrLocked
+	require.ErrorAs(t, err, &locked)
+
+	_, _, err = fc.Read("locks/repo/exclusive")
+	require.Error(t, err)
+
+	require.NoError(t, l1.Release())
+	require.NoError(t, l2.Release())
+
+	l, err := lk.TryAcquire(lock.RepoScope, lock.Exclusive)
+	require.NoError(t, err)
+	require.NoError(t, l.Release())
+}
+
+func TestExpired(t *testing.T) {
+	fc := newFakeClient(true)
+
+	// locks of a client that died without releasing them
+	old := lock.Info{
+		ID:      "0123456789abcdef",
+		Mode:    lock.Exclusive,
+		Expires: time.Now().Add(-time.Minute),
+	}
+	data, err := json.Marshal(&old)
+	require.NoError(t, err)
+	fc.objects["locks/repo/exclusive"] = data
+
+	old.Mode = lock.Shared
+	data, err = json.Marshal(&old)
+	require.NoError(t, err)
+	fc.objects["locks/repo/shared-0123456789abcdef"] = data
+
+	lk := lock.NewLocker(fc, "test", time.Minute, 0)
+	l, err := lk.TryAcquire(lock.RepoScope, lock.Exclusive)
+	require.NoError(t, err)
+	require.NotEqual(t, old.ID, l.Info().ID)
+
+	// the expired shared lock is cleaned up
+	infos, err := lk.List(lock.RepoScope)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+
+	require.NoError(t, l.Release())
+}
+
+func TestLost(t *testing.T) {
+	fc := newFakeClient(true)
+	lk := lock.NewLocker(fc, "test", time.Minute, 0)
+
+	l, err := lk.TryAcquire(lock.RepoScope, lock.Exclusive)
+	require.NoError(t, err)
+
+	// someone else takes over the lock
+	fc.objects["locks/repo/exclusive"] = []byte(`{"id":"other"}`)
+
+	var lost *lock.ErrLost
+	require.ErrorAs(t, l.Refresh(), &lost)
+
+	// and releasing it leaves their lock in place
+	require.NoError(t, l.Release())
+	require.Contains(t, fc.objects, "locks/repo/exclusive")
+}
+
+func TestSharedLost(t *testing.T) {
+	for _, conditional := range []bool{true, false} {
+		fc := newFakeClient(conditional)
+		lk := lock.NewLocker(fc, "test", time.Minute, 0)
+
+		l, err := lk.TryAcquire(lock.RepoScope, lock.Shared)
+		require.NoError(t, err)
+		require.NoError(t, l.Refresh())
+
+		// the lock expired and was cleaned up, so an exclusive lock could have
+		//   been taken since
+		require.NoError(t, fc.Delete("locks/repo/shared-"+l.Info().ID))
+
+		var lost *lock.ErrLost
+		require.ErrorAs(t, l.Refresh(), &lost)
+		require.Empty(t, fc.objects)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -83,3 +84,45 @@ func Upload(client s3io.Client, source io.Reader, jobname, label string) (string
 
 	return mkey, err
 }
+
+// Snapshot identifies a manifest by its job, label and the time it was uploaded.
+type Snapshot struct {
+	Key   string    `json:"key"`
+	Job   string    `json:"job"`
+	Label string    `json:"label"`
+	Time  time.Time `json:"time"`
+}
+
+// ParseKey splits a manifest key of the form created by Upload into its parts.
+// The time is in the local time zone, as it was when uploaded.
+func ParseKey(mkey string) (*Snapshot, error) {
+	tokens := strings.Split(mkey, "/")
+	if len(tokens) != 4 || tokens[0] != "manifests" {
+		return nil, fmt.Errorf("not a manifest key: %s", mkey)
+	}
+	jobname, label := tokens[1], tokens[2]
+
+	// the name is job-label-yyyy-mm-dd-seconds.csv.gz
+	name := strings.TrimPrefix(tokens[3], fmt.Sprintf("%s-%s-", jobname, label))
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".csv")
+
+	if len(name) != 16 || name[10] != '-' {
+		return nil, fmt.Errorf("not a manifest key: %s", mkey)
+	}
+	day, err := time.ParseInLocation("2006-01-02", name[:10], time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("not a manifest key: %s", mkey)
+	}
+	seconds, err := strconv.Atoi(name[11:])
+	if err != nil {
+		return nil, fmt.Errorf("not a manifest key: %s", mkey)
+	}
+
+	snap := Snapshot{
+		Key:   mkey,
+		Job:   jobname,
+		Label: label,
+		Time:  time.Date(day.Year(), day.Month(), day.Day(), 0, 0, seconds, 0, time.Local),
+	}
+	return &snap, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -71,3 +72,16 @@ func TestManifest(t *testing.T) {
 		fmt.Printf("new manifest: %s\n", mkey)
 	}
 }
+
+func TestParseKey(t *testing.T) {
+	snap, err := manifest.ParseKey("manifests/my-job/home/my-job-home-2023-05-29-51748.csv.gz")
+	require.NoError(t, err)
+	require.Equal(t, "my-job", snap.Job)
+	require.Equal(t, "home", snap.Label)
+	require.Equal(t, time.Date(2023, 5, 29, 14, 22, 28, 0, time.Local), snap.Time)
+
+	_, err = manifest.ParseKey("manifests/my-job/home/notes.txt")
+	require.Error(t, err)
+	_, err = manifest.ParseKey("jobs/my-job/my-job-001.yml")
+	require.Error(t, err)
+}
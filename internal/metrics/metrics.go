@@ -0,0 +1,321 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/studio1767/s3backup/internal/report"
+)
+
+// Metrics holds the latest metrics for each job/label that has been backed up
+// and renders them in the prometheus text exposition format. The same format
+// is used for node_exporter textfiles and the /metrics endpoint.
+type Metrics struct {
+	mu      sync.Mutex
+	sources map[sourceKey]*sourceMetrics
+}
+
+type sourceKey struct {
+	job   string
+	label string
+}
+
+type sourceMetrics struct {
+	lastRun          float64
+	lastSuccess      float64
+	duration         float64
+	success          float64
+	files            float64
+	bytes            float64
+	bytesTransferred float64
+	status           map[string]float64
+	actions          map[string]float64
+}
+
+func newSourceMetrics() *sourceMetrics {
+	sm := sourceMetrics{
+		status:  make(map[string]float64),
+		actions: make(map[string]float64),
+	}
+	return &sm
+}
+
+func New() *Metrics {
+	m := Metrics{
+		sources: make(map[sourceKey]*sourceMetrics),
+	}
+	return &m
+}
+
+// Update records the results of a backup run.
+func (m *Metrics) Update(run *report.Run) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, src := range run.Sources {
+		key := sourceKey{job: src.Job, label: src.Label}
+
+		sm, ok := m.sources[key]
+		if !ok {
+			sm = newSourceMetrics()
+			m.sources[key] = sm
+		}
+
+		finished := float64(src.Start.Unix()) + src.Duration
+
+		sm.lastRun = finished
+		sm.duration = src.Duration
+		sm.success = 0
+		if src.Result == report.ResultSuccess {
+			sm.success = 1
+			sm.lastSuccess = finished
+		}
+		sm.files = float64(src.Files)
+		sm.bytes = float64(src.Bytes)
+		sm.bytesTransferred = float64(src.BytesTransferred)
+
+		clear(sm.status)
+		for k, v := range src.Status {
+			sm.status[k] = float64(v)
+		}
+		clear(sm.actions)
+		for k, v := range src.Actions {
+			sm.actions[k] = float64(v)
+		}
+	}
+}
+
+type metricDef struct {
+	name  string
+	help  string
+	value func(sm *sourceMetrics) float64
+	dim   string
+	dims  func(sm *sourceMetrics) map[string]float64
+}
+
+var metricDefs = []metricDef{
+	{
+		name:  "s3backup_last_run_timestamp_seconds",
+		help:  "Time the last backup of the source finished.",
+		value: func(sm *sourceMetrics) float64 { return sm.lastRun },
+	},
+	{
+		name:  "s3backup_last_success_timestamp_seconds",
+		help:  "Time the last successful backup of the source finished.",
+		value: func(sm *sourceMetrics) float64 { return sm.lastSuccess },
+	},
+	{
+		name:  "s3backup_last_run_success",
+		help:  "Whether the last backup of the source completed without failures.",
+		value: func(sm *sourceMetrics) float64 { return sm.success },
+	},
+	{
+		name:  "s3backup_last_run_duration_seconds",
+		help:  "Duration of the last backup of the source.",
+		value: func(sm *sourceMetrics) float64 { return sm.duration },
+	},
+	{
+		name:  "s3backup_last_run_files",
+		help:  "Number of files processed in the last backup of the source.",
+		value: func(sm *sourceMetrics) float64 { return sm.files },
+	},
+	{
+		name:  "s3backup_last_run_bytes",
+		help:  "Size of the files processed in the last backup of the source.",
+		value: func(sm *sourceMetrics) float64 { return sm.bytes },
+	},
+	{
+		name:  "s3backup_last_run_uploaded_bytes",
+		help:  "Bytes uploaded in the last backup of the source.",
+		value: func(sm *sourceMetrics) float64 { return sm.bytesTransferred },
+	},
+	{
+		name: "s3backup_last_run_files_by_status",
+		help: "Number of files by status in the last backup of the source.",
+		dim:  "status",
+		dims: func(sm *sourceMetrics) map[string]float64 { return sm.status },
+	},
+	{
+		name: "s3backup_last_run_files_by_action",
+		help: "Number of files by action in the last backup of the source.",
+		dim:  "action",
+		dims: func(sm *sourceMetrics) map[string]float64 { return sm.actions },
+	},
+}
+
+// WriteTo writes the metrics in the prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// sort the sources for stable output
+	keys := make([]sourceKey, 0, len(m.sources))
+	for key := range m.sources {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].job != keys[j].job {
+			return keys[i].job < keys[j].job
+		}
+		return keys[i].label < keys[j].label
+	})
+
+	var sb strings.Builder
+	for _, def := range metricDefs {
+		fmt.Fprintf(&sb, "# HELP %s %s\n", def.name, def.help)
+		fmt.Fprintf(&sb, "# TYPE %s gauge\n", def.name)
+
+		for _, key := range keys {
+			sm := m.sources[key]
+			labels := fmt.Sprintf("job=\"%s\",label=\"%s\"", escape(key.job), escape(key.label))
+
+			if def.dims == nil {
+				fmt.Fprintf(&sb, "%s{%s} %s\n", def.name, labels, formatValue(def.value(sm)))
+				continue
+			}
+
+			dims := def.dims(sm)
+			names := make([]string, 0, len(dims))
+			for name := range dims {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(&sb, "%s{%s,%s=\"%s\"} %s\n", def.name, labels, def.dim, escape(name), formatValue(dims[name]))
+			}
+		}
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics for scraping.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTextfile writes the metrics to a temporary file in the same directory
+// and renames it into place, as node_exporter expects.
+func (m *Metrics) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".metrics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = m.WriteTo(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Chmod(0644)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+var sampleRe = regexp.MustCompile(`^(\w+)\{job="((?:[^"\\]|\\.)*)",label="((?:[^"\\]|\\.)*)"(?:,(\w+)="((?:[^"\\]|\\.)*)")?\} (\S+)$`)
+
+// LoadTextfile loads the metrics from a textfile written by a previous run so that
+// the metrics for other jobs and labels, and the last success times, are kept.
+// A missing file is not an error.
+func (m *Metrics) LoadTextfile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		matches := sampleRe.FindStringSubmatch(scanner.Text())
+		if matches == nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(matches[6], 64)
+		if err != nil {
+			continue
+		}
+
+		key := sourceKey{job: unescape(matches[2]), label: unescape(matches[3])}
+		sm, ok := m.sources[key]
+		if !ok {
+			sm = newSourceMetrics()
+			m.sources[key] = sm
+		}
+
+		switch matches[1] {
+		case "s3backup_last_run_timestamp_seconds":
+			sm.lastRun = value
+		case "s3backup_last_success_timestamp_seconds":
+			sm.lastSuccess = value
+		case "s3backup_last_run_success":
+			sm.success = value
+		case "s3backup_last_run_duration_seconds":
+			sm.duration = value
+		case "s3backup_last_run_files":
+			sm.files = value
+		case "s3backup_last_run_bytes":
+			sm.bytes = value
+		case "s3backup_last_run_uploaded_bytes":
+			sm.bytesTransferred = value
+		case "s3backup_last_run_files_by_status":
+			sm.status[unescape(matches[5])] = value
+		case "s3backup_last_run_files_by_action":
+			sm.actions[unescape(matches[5])] = value
+		}
+	}
+
+	return scanner.Err()
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func escape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return s
+}
+
+func unescape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' {
+				sb.WriteByte('\n')
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
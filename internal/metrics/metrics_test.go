@@ -0,0 +1,75 @@
+package metrics_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/metrics"
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/report"
+)
+
+func makeRun(label string, start time.Time, err error) *report.Run {
+	run := report.NewRun("s3backup", "bucket", "job")
+
+	src := report.NewSource("job", label, "/tmp")
+	src.Start = start
+	src.Add(&ops.EntryInfo{Status: ops.StatusNew, RelPath: "a", RawSize: 10, Action: ops.Uploaded}, 12)
+	src.Finish(err)
+	src.Duration = 5
+	run.Add(src)
+	run.Finish()
+
+	return run
+}
+
+func TestWriteMetrics(t *testing.T) {
+	m := metrics.New()
+	m.Update(makeRun("home", time.Unix(1000, 0), nil))
+
+	buffer := bytes.NewBuffer(nil)
+	_, err := m.WriteTo(buffer)
+	require.NoError(t, err)
+
+	text := buffer.String()
+	require.Contains(t, text, "# TYPE s3backup_last_success_timestamp_seconds gauge\n")
+	require.Contains(t, text, `s3backup_last_run_success{job="job",label="home"} 1`)
+	require.Contains(t, text, `s3backup_last_run_uploaded_bytes{job="job",label="home"} 12`)
+	require.Contains(t, text, `s3backup_last_run_files_by_action{job="job",label="home",action="uploaded"} 1`)
+}
+
+func TestTextfileKeepsLastSuccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s3backup.prom")
+
+	// a successful run followed by a failed run
+	m := metrics.New()
+	require.NoError(t, m.LoadTextfile(path))
+	m.Update(makeRun("home", time.Unix(1000, 0), nil))
+	m.Update(makeRun("projects", time.Unix(1000, 0), nil))
+	require.NoError(t, m.WriteTextfile(path))
+
+	m = metrics.New()
+	require.NoError(t, m.LoadTextfile(path))
+	m.Update(makeRun("home", time.Unix(2000, 0), errors.New("failed")))
+	require.NoError(t, m.WriteTextfile(path))
+
+	// reload and check
+	m = metrics.New()
+	require.NoError(t, m.LoadTextfile(path))
+
+	buffer := bytes.NewBuffer(nil)
+	_, err := m.WriteTo(buffer)
+	require.NoError(t, err)
+
+	lines := strings.Split(buffer.String(), "\n")
+	require.Contains(t, lines, `s3backup_last_success_timestamp_seconds{job="job",label="home"} 1005`)
+	require.Contains(t, lines, `s3backup_last_run_timestamp_seconds{job="job",label="home"} 2005`)
+	require.Contains(t, lines, `s3backup_last_run_success{job="job",label="home"} 0`)
+	require.Contains(t, lines, `s3backup_last_run_success{job="job",label="projects"} 1`)
+}
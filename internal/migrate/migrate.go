@@ -0,0 +1,101 @@
+// Package migrate upgrades repositories from older formats to the current one, a
+// version at a time.
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// Step upgrades a repository from one format version to the next.
+type Step struct {
+	From        int
+	To          int
+	Description string
+
+	// the features the repository has after the step
+	Features []string
+
+	// Apply changes the repository; with dry_run set, it only reports what it would
+	// change. It's nil for steps that only update the marker.
+	Apply func(client s3io.Client, dry_run bool, out io.Writer) error
+}
+
+// Steps are all the upgrades, in order.
+var Steps = []*Step{
+	{
+		From:        0,
+		To:          1,
+		Description: "record the format and features in " + s3io.RepoConfigKey,
+		Features:    s3io.Features,
+	},
+}
+
+// Plan returns the steps to upgrade a repository at the version to the current one.
+func Plan(version int) ([]*Step, error) {
+	if version > s3io.RepoVersion {
+		return nil, fmt.Errorf("repository version %d is newer than %d", version, s3io.RepoVersion)
+	}
+
+	var steps []*Step
+	for _, step := range Steps {
+		if step.From < version {
+			continue
+		}
+		if step.From != version {
+			return nil, fmt.Errorf("no upgrade from repository version %d", version)
+		}
+		steps = append(steps, step)
+		version = step.To
+	}
+
+	if version != s3io.RepoVersion {
+		return nil, fmt.Errorf("no upgrade from repository version %d", version)
+	}
+	return steps, nil
+}
+
+// Run applies the steps to the repository with the config, writing the marker after
+// each one so an interrupted upgrade can carry on from where it stopped. With
+// dry_run set, the steps are only reported.
+func Run(client s3io.Client, rc *s3io.RepoConfig, steps []*Step, dry_run bool, out io.Writer) error {
+	cfg := *rc
+	if cfg.Created.IsZero() {
+		cfg.Created = time.Now().UTC().Truncate(time.Second)
+	}
+
+	for _, step := range steps {
+		if step.From != cfg.Version {
+			return fmt.Errorf("step from version %d can't be applied to version %d", step.From, cfg.Version)
+		}
+		fmt.Fprintf(out, "version %d -> %d: %s\n", step.From, step.To, step.Description)
+
+		if step.Apply != nil {
+			err := step.Apply(client, dry_run, out)
+			if err != nil {
+				return fmt.Errorf("upgrade to version %d failed: %w", step.To, err)
+			}
+		}
+
+		cfg.Version = step.To
+		cfg.Features = append([]string(nil), step.Features...)
+		if dry_run {
+			continue
+		}
+
+		data, err := cfg.Marshal()
+		if err != nil {
+			return err
+		}
+		_, err = client.Upload(s3io.RepoConfigKey, bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", s3io.RepoConfigKey, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package migrate_test
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/migrate"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// fakeClient keeps the uploads in memory.
+type fakeClient struct {
+	s3io.Client
+
+	objects map[string][]byte
+}
+
+func (fc *fakeClient) Upload(key string, source io.Reader) (int64, error) {
+	data, err := io.ReadAll(source)
+	if err != nil {
+		return 0, err
+	}
+	fc.objects[key] = data
+	return int64(len(data)), nil
+}
+
+func TestSteps(t *testing.T) {
+	// the steps have to follow on from each other up to the current version
+	version := 0
+	for _, step := range migrate.Steps {
+		require.Equal(t, version, step.From)
+		require.Equal(t, step.From+1, step.To)
+		version = step.To
+	}
+	require.Equal(t, s3io.RepoVersion, version)
+}
+
+func TestPlan(t *testing.T) {
+	steps, err := migrate.Plan(0)
+	require.NoError(t, err)
+	require.Len(t, steps, s3io.RepoVersion)
+
+	steps, err = migrate.Plan(s3io.RepoVersion)
+	require.NoError(t, err)
+	require.Empty(t, steps)
+
+	_, err = migrate.Plan(s3io.RepoVersion + 1)
+	require.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	fc := &fakeClient{
+		objects: make(map[string][]byte),
+	}
+	steps, err := migrate.Plan(0)
+	require.NoError(t, err)
+
+	// a dry run doesn't change anything
+	var out bytes.Buffer
+	require.NoError(t, migrate.Run(fc, &s3io.RepoConfig{}, steps, true, &out))
+	require.Contains(t, out.String(), "version 0 -> 1")
+	require.Empty(t, fc.objects)
+
+	require.NoError(t, migrate.Run(fc, &s3io.RepoConfig{}, steps, false, &out))
+
+	rc, err := s3io.ParseRepoConfig(fc.objects[s3io.RepoConfigKey])
+	require.NoError(t, err)
+	require.Equal(t, s3io.RepoVersion, rc.Version)
+	require.False(t, rc.Created.IsZero())
+	require.Equal(t, s3io.Features, rc.Features)
+	require.NoError(t, rc.Check())
+
+	// the steps can't be applied again
+	require.Error(t, migrate.Run(fc, rc, steps, false, &out))
+}
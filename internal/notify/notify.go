@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/report"
+)
+
+const DefaultTimeout = 30 * time.Second
+
+// Send notifies each target that matches the result of the run. All targets
+// are tried; the errors from any that failed are returned.
+func Send(targets []job.Notification, run *report.Run) []error {
+	// render the report once for all the targets
+	payload := bytes.NewBuffer(nil)
+	err := run.Write(payload)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, target := range targets {
+		matched, err := matches(target.On, run.Result)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		timeout := target.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+		switch {
+		case target.Webhook != "" && target.Command != "":
+			err = fmt.Errorf("notification has both webhook and command")
+		case target.Webhook != "":
+			err = sendWebhook(ctx, target.Webhook, payload.Bytes())
+		case target.Command != "":
+			err = runCommand(ctx, target.Command, run, payload.Bytes())
+		default:
+			err = fmt.Errorf("notification has no webhook or command")
+		}
+		cancel()
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+func matches(on string, result report.Result) (bool, error) {
+	switch on {
+	case "", "always":
+		return true, nil
+	case "success":
+		return result == report.ResultSuccess, nil
+	case "failure":
+		return result != report.ResultSuccess, nil
+	}
+	return false, fmt.Errorf("unknown notification condition: %s", on)
+}
+
+func sendWebhook(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook failed: %s: %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+func runCommand(ctx context.Context, command string, run *report.Run, payload []byte) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"S3BU_JOB="+run.Job,
+		"S3BU_BUCKET="+run.Bucket,
+		"S3BU_RESULT="+string(run.Result),
+	)
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("notification command failed: %s: %w", command, err)
+	}
+
+	return nil
+}
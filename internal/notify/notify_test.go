@@ -0,0 +1,93 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/notify"
+	"github.com/studio1767/s3backup/internal/report"
+)
+
+func makeRun(err error) *report.Run {
+	run := report.NewRun("s3backup", "bucket", "job")
+	src := report.NewSource("job", "label", "/tmp")
+	src.Finish(err)
+	run.Add(src)
+	run.Finish()
+	return run
+}
+
+func TestWebhookOnFailure(t *testing.T) {
+	var received []*report.Run
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var run report.Run
+		data, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(data, &run))
+		received = append(received, &run)
+	}))
+	defer server.Close()
+
+	targets := []job.Notification{
+		{Webhook: server.URL, On: "failure"},
+	}
+
+	errs := notify.Send(targets, makeRun(nil))
+	require.Empty(t, errs)
+	require.Len(t, received, 0)
+
+	errs = notify.Send(targets, makeRun(errors.New("failed")))
+	require.Empty(t, errs)
+	require.Len(t, received, 1)
+	require.Equal(t, report.ResultFatal, received[0].Result)
+}
+
+func TestWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	targets := []job.Notification{
+		{Webhook: server.URL},
+	}
+
+	errs := notify.Send(targets, makeRun(nil))
+	require.Len(t, errs, 1)
+}
+
+func TestCommandReceivesReport(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "report.json")
+
+	targets := []job.Notification{
+		{Command: fmt.Sprintf("cat > %s", output), On: "success"},
+	}
+
+	errs := notify.Send(targets, makeRun(nil))
+	require.Empty(t, errs)
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+
+	var run report.Run
+	require.NoError(t, json.Unmarshal(data, &run))
+	require.Equal(t, report.ResultSuccess, run.Result)
+}
+
+func TestUnknownCondition(t *testing.T) {
+	targets := []job.Notification{
+		{Command: "true", On: "sometimes"},
+	}
+
+	errs := notify.Send(targets, makeRun(nil))
+	require.Len(t, errs, 1)
+}
@@ -0,0 +1,78 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/studio1767/s3backup/internal/job"
+)
+
+// DirFilter applies the job's rules for skipping directories: the top level
+// include/exclude lists, the skip_dirs at all levels and skip_dir_items.
+type DirFilter struct {
+	include_top_dirs map[string]bool
+	exclude_top_dirs map[string]bool
+	skip_dirs        map[string]bool
+	skip_dir_items   map[string]bool
+}
+
+func NewDirFilter(job *job.Job) *DirFilter {
+	// convert the include/exclude files to maps for easier lookup
+	df := DirFilter{
+		include_top_dirs: make(map[string]bool),
+		exclude_top_dirs: make(map[string]bool),
+		skip_dirs:        make(map[string]bool),
+		skip_dir_items:   make(map[string]bool),
+	}
+
+	for _, dir := range job.IncludeTopDirs {
+		df.include_top_dirs[dir] = true
+	}
+	for _, dir := range job.ExcludeTopDirs {
+		df.exclude_top_dirs[dir] = true
+	}
+	for _, dir := range job.SkipDirs {
+		df.skip_dirs[dir] = true
+	}
+	for _, dir := range job.SkipDirItems {
+		df.skip_dir_items[dir] = true
+	}
+
+	return &df
+}
+
+// SkipDir reports if the directory with the name, found in a directory at
+// the level below the source root, should be skipped.
+func (df *DirFilter) SkipDir(name string, level int) bool {
+	skip_dir := false
+
+	// if we're at level 0, check the top level include/exclude lists
+	if level == 0 {
+		if len(df.include_top_dirs) > 0 {
+			skip_dir = !df.include_top_dirs[name]
+		}
+		if len(df.exclude_top_dirs) > 0 && df.exclude_top_dirs[name] {
+			skip_dir = true
+		}
+	}
+
+	// check skip_dirs at all levels
+	if skip_dir == false && len(df.skip_dirs) > 0 {
+		skip_dir = df.skip_dirs[name]
+	}
+
+	return skip_dir
+}
+
+// SkipContents reports if the directory contains one of the skip_dir_items
+// and so its contents should be skipped.
+func (df *DirFilter) SkipContents(dir string) bool {
+	for skip := range df.skip_dir_items {
+		_, err := os.Stat(filepath.Join(dir, skip))
+		if err == nil {
+			// no error, so the file exists... bail out
+			return true
+		}
+	}
+	return false
+}
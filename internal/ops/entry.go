@@ -15,6 +15,20 @@ const (
 	StatusNotFound
 )
 
+func (es EntryStatus) String() string {
+	switch es {
+	case StatusOk:
+		return "ok"
+	case StatusNew:
+		return "new"
+	case StatusModified:
+		return "modified"
+	case StatusNotFound:
+		return "not_found"
+	}
+	return "unknown"
+}
+
 // OpAction represents any action that has been performed on a file system
 // object.
 type OpAction int
@@ -23,8 +37,26 @@ const (
 	NoAction OpAction = iota
 	Uploaded
 	Failed
+	Downloaded
+	Skipped
 )
 
+func (oa OpAction) String() string {
+	switch oa {
+	case NoAction:
+		return "no_action"
+	case Uploaded:
+		return "uploaded"
+	case Failed:
+		return "failed"
+	case Downloaded:
+		return "downloaded"
+	case Skipped:
+		return "skipped"
+	}
+	return "unknown"
+}
+
 // ItemInfo represents the meta-data associate with a file system object
 // as well as the application required state and status flags. This
 // structure is passed between operators to help them determine if their
@@ -39,4 +71,7 @@ type EntryInfo struct {
 	Mode          os.FileMode
 	Action        OpAction
 	ActionMessage string
+
+	// the manifest entry for the same path, set by the stream comparer
+	Reference *EntryInfo
 }
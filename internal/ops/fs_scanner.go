@@ -16,34 +16,12 @@ func NewFsScanner(ctx context.Context, source string, job *job.Job) <-chan *Entr
 		source += "/"
 	}
 
-	// convert the include/exclude files to maps for easier lookup
-	include_top_dirs := make(map[string]bool)
-	exclude_top_dirs := make(map[string]bool)
-	skip_dirs := make(map[string]bool)
-	skip_dir_items := make(map[string]bool)
-
-	for _, dir := range job.IncludeTopDirs {
-		include_top_dirs[dir] = true
-	}
-	for _, dir := range job.ExcludeTopDirs {
-		exclude_top_dirs[dir] = true
-	}
-	for _, dir := range job.SkipDirs {
-		skip_dirs[dir] = true
-	}
-	for _, dir := range job.SkipDirItems {
-		skip_dir_items[dir] = true
-	}
-
 	out := make(chan *EntryInfo, 10)
 	fs := fsScanner{
-		ctx:              ctx,
-		out:              out,
-		source:           source,
-		include_top_dirs: include_top_dirs,
-		exclude_top_dirs: exclude_top_dirs,
-		skip_dirs:        skip_dirs,
-		skip_dir_items:   skip_dir_items,
+		ctx:    ctx,
+		out:    out,
+		source: source,
+		filter: NewDirFilter(job),
 	}
 	go func() {
 		defer close(fs.out)
@@ -54,23 +32,16 @@ func NewFsScanner(ctx context.Context, source string, job *job.Job) <-chan *Entr
 }
 
 type fsScanner struct {
-	ctx              context.Context
-	out              chan<- *EntryInfo
-	source           string
-	include_top_dirs map[string]bool
-	exclude_top_dirs map[string]bool
-	skip_dirs        map[string]bool
-	skip_dir_items   map[string]bool
+	ctx    context.Context
+	out    chan<- *EntryInfo
+	source string
+	filter *DirFilter
 }
 
 func (fs *fsScanner) run(dir string, level int) {
 	// check for the existance of skip_dir_files
-	for skip, _ := range fs.skip_dir_items {
-		_, err := os.Stat(filepath.Join(dir, skip))
-		if err == nil {
-			// no error, so the file exists... bail out
-			return
-		}
+	if fs.filter.SkipContents(dir) {
+		return
 	}
 
 	// read the directory contents
@@ -108,26 +79,8 @@ func (fs *fsScanner) run(dir string, level int) {
 			}
 
 		} else if entry.Type().IsDir() {
-			// run some checks to see if we're skipping this directory
-			skip_dir := false
-
-			// if we're at level 0, check the top level include/exclude lists
-			if level == 0 {
-				if len(fs.include_top_dirs) > 0 {
-					skip_dir = !fs.include_top_dirs[entry.Name()]
-				}
-				if len(fs.exclude_top_dirs) > 0 && fs.exclude_top_dirs[entry.Name()] {
-					skip_dir = true
-				}
-			}
-
-			// check skip_dirs at all levels
-			if skip_dir == false && len(fs.skip_dirs) > 0 {
-				skip_dir = fs.skip_dirs[entry.Name()]
-			}
-
-			// scan into the subdirectory
-			if skip_dir == false {
+			// scan into the subdirectory unless we're skipping it
+			if fs.filter.SkipDir(entry.Name(), level) == false {
 				fpath := filepath.Join(dir, entry.Name())
 				fs.run(fpath, level+1)
 			}
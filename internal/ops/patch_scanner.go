@@ -0,0 +1,187 @@
+package ops
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/studio1767/s3backup/internal/job"
+)
+
+// NewPatchScanner produces the same stream as NewFsScanner would for the source, but
+// only scans the changed paths. Everything else is taken from the previous manifest,
+// so feeding this stream into NewStreamComparer with the same manifest gives a full
+// and up to date view of the source. A changed path can be a file or a directory,
+// which is scanned in full; paths that no longer exist drop out of the stream.
+func NewPatchScanner(ctx context.Context, source string, job *job.Job, inMani <-chan *EntryInfo, changed []string) <-chan *EntryInfo {
+
+	// make sure we have a trailing slash... assumed by the scanner
+	if !strings.HasSuffix(source, "/") {
+		source += "/"
+	}
+
+	out := make(chan *EntryInfo, 10)
+	ps := patchScanner{
+		ctx:     ctx,
+		out:     out,
+		source:  source,
+		filter:  NewDirFilter(job),
+		inMani:  inMani,
+		changed: make(map[string]bool),
+	}
+
+	// clean up the changed paths, dropping any covered by a changed directory
+	for _, path := range changed {
+		path = filepath.Clean(path)
+		if path == "." || strings.HasPrefix(path, "..") {
+			continue
+		}
+		ps.changed[path] = true
+	}
+	for path := range ps.changed {
+		if ps.covered(filepath.Dir(path)) {
+			delete(ps.changed, path)
+		}
+	}
+
+	go ps.run()
+
+	return out
+}
+
+type patchScanner struct {
+	ctx     context.Context
+	out     chan<- *EntryInfo
+	source  string
+	filter  *DirFilter
+	inMani  <-chan *EntryInfo
+	changed map[string]bool
+}
+
+// covered reports if the path or one of its parents has changed.
+func (ps *patchScanner) covered(path string) bool {
+	for path != "." && path != "/" {
+		if ps.changed[path] {
+			return true
+		}
+		path = filepath.Dir(path)
+	}
+	return false
+}
+
+func (ps *patchScanner) run() {
+	defer close(ps.out)
+
+	// scan the changed paths and sort them into manifest order
+	fresh := ps.scan()
+	sort.Slice(fresh, func(i, j int) bool {
+		return order_paths(fresh[i].RelPath, fresh[j].RelPath) < 0
+	})
+
+	// merge with the unchanged entries from the manifest
+	for ei := range ps.inMani {
+		if ps.covered(ei.RelPath) {
+			continue
+		}
+		for len(fresh) > 0 && order_paths(fresh[0].RelPath, ei.RelPath) < 0 {
+			if !ps.send(fresh[0]) {
+				return
+			}
+			fresh = fresh[1:]
+		}
+		if !ps.send(ei) {
+			return
+		}
+	}
+	for _, ei := range fresh {
+		if !ps.send(ei) {
+			return
+		}
+	}
+}
+
+func (ps *patchScanner) send(ei *EntryInfo) bool {
+	select {
+	case <-ps.ctx.Done():
+		return false
+	case ps.out <- ei:
+		return true
+	}
+}
+
+// scan stats the changed paths, scanning into directories, and returns the entries
+// found.
+func (ps *patchScanner) scan() []*EntryInfo {
+	ch := make(chan *EntryInfo, 10)
+	fs := fsScanner{
+		ctx:    ps.ctx,
+		out:    ch,
+		source: ps.source,
+		filter: ps.filter,
+	}
+
+	go func() {
+		defer close(ch)
+
+		for path := range ps.changed {
+			fpath := filepath.Join(ps.source, path)
+			info, err := os.Lstat(fpath)
+			if err != nil {
+				// removed since it was changed
+				continue
+			}
+			if ps.skipped(path, info.IsDir()) {
+				continue
+			}
+
+			if info.Mode().IsRegular() {
+				ch <- &EntryInfo{
+					Status:  StatusNew,
+					RelPath: path,
+					RawSize: info.Size(),
+					ModTime: info.ModTime().Unix(),
+					Mode:    info.Mode(),
+					Action:  NoAction,
+				}
+			} else if info.IsDir() {
+				fs.run(fpath, strings.Count(path, string(os.PathSeparator))+1)
+			}
+		}
+	}()
+
+	var entries []*EntryInfo
+	for ei := range ch {
+		entries = append(entries, ei)
+	}
+	return entries
+}
+
+// skipped applies the directory rules to the parents of the path, and to the
+// path itself if it's a directory, as the full scan would have.
+func (ps *patchScanner) skipped(path string, isdir bool) bool {
+	segments := strings.Split(path, string(os.PathSeparator))
+
+	dir := ps.source
+	for level, name := range segments {
+		if level == len(segments)-1 && !isdir {
+			break
+		}
+		if ps.filter.SkipContents(dir) || ps.filter.SkipDir(name, level) {
+			return true
+		}
+		dir = filepath.Join(dir, name)
+	}
+
+	return false
+}
+
+// order_paths orders paths by segment as the scanners produce them.
+func order_paths(path1, path2 string) int {
+	val := compare_paths(path1, path2)
+	if val != 0 {
+		return val
+	}
+	return strings.Count(path1, string(os.PathSeparator)) - strings.Count(path2, string(os.PathSeparator))
+}
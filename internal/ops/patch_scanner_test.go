@@ -0,0 +1,80 @@
+package ops_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/ops"
+)
+
+func writeFile(t *testing.T, root, path, content string) {
+	fpath := filepath.Join(root, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0755))
+	require.NoError(t, os.WriteFile(fpath, []byte(content), 0644))
+}
+
+func scan(ch <-chan *ops.EntryInfo) map[string]int64 {
+	entries := make(map[string]int64)
+	for ei := range ch {
+		entries[ei.RelPath] = ei.RawSize
+	}
+	return entries
+}
+
+func TestPatchScannerMatchesFullScan(t *testing.T) {
+	root := t.TempDir()
+	job := &job.Job{SkipDirs: []string{"cache"}}
+
+	writeFile(t, root, "a.txt", "a")
+	writeFile(t, root, "b/c.txt", "c")
+	writeFile(t, root, "b/d/e.txt", "e")
+	writeFile(t, root, "f.txt", "f")
+
+	// the manifest from the first scan
+	var manifest []*ops.EntryInfo
+	for ei := range ops.NewFsScanner(context.Background(), root, job) {
+		manifest = append(manifest, ei)
+	}
+
+	// change some files
+	writeFile(t, root, "a.txt", "aaa")
+	writeFile(t, root, "b/d/g.txt", "g")
+	writeFile(t, root, "b/d/cache/h.txt", "h")
+	writeFile(t, root, "b0/i.txt", "i")
+	require.NoError(t, os.Remove(filepath.Join(root, "f.txt")))
+
+	changed := []string{"a.txt", "b/d", "b/d/g.txt", "b0", "f.txt", "b/d/cache/h.txt"}
+
+	mch := make(chan *ops.EntryInfo, len(manifest))
+	for _, ei := range manifest {
+		mch <- ei
+	}
+	close(mch)
+
+	// the patched scan must be in the same order as a full scan
+	var patched []string
+	for ei := range ops.NewPatchScanner(context.Background(), root, job, mch, changed) {
+		patched = append(patched, ei.RelPath)
+	}
+	var full []string
+	for ei := range ops.NewFsScanner(context.Background(), root, job) {
+		full = append(full, ei.RelPath)
+	}
+	require.Equal(t, full, patched)
+
+	// and the sizes must be up to date
+	mch = make(chan *ops.EntryInfo, len(manifest))
+	for _, ei := range manifest {
+		mch <- ei
+	}
+	close(mch)
+
+	require.Equal(t,
+		scan(ops.NewFsScanner(context.Background(), root, job)),
+		scan(ops.NewPatchScanner(context.Background(), root, job, mch, changed)))
+}
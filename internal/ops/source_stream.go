@@ -0,0 +1,28 @@
+package ops
+
+import (
+	"context"
+
+	"github.com/studio1767/s3backup/internal/job"
+)
+
+// This operator applies the job's extension filters to the scanned files of a
+// source and, if there's a stream from the previous manifest, compares them with
+// it. It's the part of the chain shared by backups, dry runs, estimates and diffs,
+// so they all see the same files. The manifest stream can be nil.
+func NewSourceStream(ctx context.Context, in <-chan *EntryInfo, job *job.Job, inMani <-chan *EntryInfo) <-chan *EntryInfo {
+
+	ch := in
+	if len(job.IncludeExtensions) > 0 {
+		ch = NewFileExtensionFilter(ctx, ch, job.IncludeExtensions, true)
+	}
+	if len(job.ExcludeExtensions) > 0 {
+		ch = NewFileExtensionFilter(ctx, ch, job.ExcludeExtensions, false)
+	}
+
+	if inMani != nil {
+		ch = NewStreamComparer(ctx, ch, inMani)
+	}
+
+	return ch
+}
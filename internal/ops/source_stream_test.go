@@ -0,0 +1,49 @@
+package ops_test
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/job"
+	"github.com/studio1767/s3backup/internal/ops"
+)
+
+func TestSourceStream(t *testing.T) {
+	root := t.TempDir()
+	job := &job.Job{ExcludeExtensions: []string{"jpg"}}
+
+	writeFile(t, root, "a.txt", "a")
+	writeFile(t, root, "b.jpg", "b")
+	writeFile(t, root, "c.txt", "c")
+
+	// without a manifest, the files are only filtered
+	var manifest []*ops.EntryInfo
+	for ei := range ops.NewSourceStream(context.Background(), ops.NewFsScanner(context.Background(), root, job), job, nil) {
+		manifest = append(manifest, ei)
+	}
+	require.Len(t, manifest, 2)
+	require.Equal(t, "a.txt", manifest[0].RelPath)
+	require.Equal(t, "c.txt", manifest[1].RelPath)
+
+	// with one, they're compared with it
+	writeFile(t, root, "c.txt", "ccc")
+	writeFile(t, root, "d.txt", "d")
+
+	mch := make(chan *ops.EntryInfo, len(manifest))
+	for _, ei := range manifest {
+		mch <- ei
+	}
+	close(mch)
+
+	status := make(map[string]ops.EntryStatus)
+	for ei := range ops.NewSourceStream(context.Background(), ops.NewFsScanner(context.Background(), root, job), job, mch) {
+		status[ei.RelPath] = ei.Status
+	}
+	require.Equal(t, map[string]ops.EntryStatus{
+		"a.txt": ops.StatusOk,
+		"c.txt": ops.StatusModified,
+		"d.txt": ops.StatusNew,
+	}, status)
+}
@@ -128,9 +128,13 @@ func (sc *streamComparer) run() {
 
 		// if the relpaths are the same, check the attributes
 		if val == 0 {
-			// initialise the status and hash
+			// initialise the status and hash; an entry from another manifest
+			//   already has its own hash
 			hFsys.Status = StatusOk
-			hFsys.Hash = hMani.Hash
+			hFsys.Reference = hMani
+			if hFsys.Hash == "" {
+				hFsys.Hash = hMani.Hash
+			}
 
 			// if size or modtime are different, flag as changed (or potentially changed)
 			if hFsys.RawSize != hMani.RawSize || hFsys.ModTime != hMani.ModTime {
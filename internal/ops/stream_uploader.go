@@ -0,0 +1,68 @@
+package ops
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// UploadStream hashes and uploads the data from the reader as it streams through,
+// filling in the hash, sizes and action of the entry. As the content hash isn't known
+// until the stream ends, the data is uploaded to a temporary key under 'tmp/' and
+// then moved to its content-hash key, or deleted if that content is already in the
+// bucket.
+//
+// The finish function is called once the upload ends, with the upload error if
+// there was one, so the producer of the stream can clean up. If it returns an error,
+// the stream is treated as incomplete and the upload is discarded.
+func UploadStream(client s3io.Client, source io.Reader, info *EntryInfo, compress bool, finish func(error) error) {
+	// the temporary key to upload to
+	rnd := make([]byte, 16)
+	rand.Read(rnd)
+	tmpkey := fmt.Sprintf("tmp/%s", hex.EncodeToString(rnd))
+
+	// hash the data on the way through
+	h := sha256.New()
+	counter := s3io.NewReadCounter(io.TeeReader(source, h))
+	defer counter.Close()
+
+	nbytes, err := client.UploadEncrypted(tmpkey, counter, compress)
+	if finish != nil {
+		ferr := finish(err)
+		if err == nil {
+			err = ferr
+		}
+	}
+	if err != nil {
+		client.Delete(tmpkey)
+		info.Action = Failed
+		info.ActionMessage = fmt.Sprintf("failed to upload %s: %s", info.RelPath, err)
+		return
+	}
+
+	info.Hash = hex.EncodeToString(h.Sum(nil))
+	info.RawSize = counter.TotalBytes()
+
+	// move the data to its key unless it's already there
+	key := fmt.Sprintf("data/%s/%s", info.Hash[:4], info.Hash)
+	if exists, _ := client.Exists(key); exists {
+		client.Delete(tmpkey)
+		info.Action = NoAction
+		return
+	}
+
+	err = client.Move(tmpkey, key)
+	if err != nil {
+		client.Delete(tmpkey)
+		info.Action = Failed
+		info.ActionMessage = fmt.Sprintf("failed to move %s into place: %s", info.RelPath, err)
+		return
+	}
+
+	info.Action = Uploaded
+	info.UploadedSize = nbytes
+}
@@ -0,0 +1,232 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+// Tracker accumulates the progress of a backup or restore run and
+// periodically reports it. When the output is a terminal, a single status
+// line is redrawn in place; otherwise a log line is written every LogInterval.
+//
+// All output for the run should go through Printf so that it doesn't get
+// tangled up with the status line.
+type Tracker struct {
+	mu   sync.Mutex
+	out  io.Writer
+	tty  bool
+	quit chan struct{}
+	done chan struct{}
+
+	name  string
+	start time.Time
+
+	totalFiles int64
+	totalBytes int64
+
+	files     int64
+	bytes     int64
+	hashed    int64
+	uploaded  int64
+	statusLen int
+}
+
+const (
+	TTYInterval = 500 * time.Millisecond
+	LogInterval = 30 * time.Second
+)
+
+// NewTracker creates a progress tracker writing to the file. If enabled is false,
+// the tracker only passes Printf output through and never reports progress.
+func NewTracker(out *os.File, enabled bool) *Tracker {
+	tr := Tracker{
+		out: out,
+	}
+	if enabled {
+		tr.tty = isTerminal(out)
+		tr.quit = make(chan struct{})
+	}
+	return &tr
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return st.Mode()&os.ModeCharDevice != 0
+}
+
+// Start resets the counters and starts the reporting for the named operation.
+func (tr *Tracker) Start(name string) {
+	tr.Stop()
+
+	tr.mu.Lock()
+	tr.name = name
+	tr.start = time.Now()
+	tr.totalFiles, tr.totalBytes = 0, 0
+	tr.files, tr.bytes, tr.hashed, tr.uploaded = 0, 0, 0, 0
+
+	if tr.quit == nil {
+		tr.mu.Unlock()
+		return
+	}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	tr.quit, tr.done = quit, done
+	tr.mu.Unlock()
+
+	go tr.run(quit, done)
+}
+
+// Stop stops the reporting and clears the status line.
+func (tr *Tracker) Stop() {
+	tr.mu.Lock()
+	quit, done := tr.quit, tr.done
+	tr.done = nil
+	tr.mu.Unlock()
+
+	if done == nil {
+		return
+	}
+
+	// the reporting takes the lock, so it's waited for without holding it
+	close(quit)
+	<-done
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.clear()
+}
+
+// SetEstimate sets the expected totals for the operation. A zero total means
+// unknown and no ETA is reported.
+func (tr *Tracker) SetEstimate(files, bytes int64) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.totalFiles = files
+	tr.totalBytes = bytes
+}
+
+// Add records a processed file. The bytes argument is the raw size of the file,
+// hashed is the number of bytes read to generate the content hash and
+// transferred is the number of bytes uploaded or downloaded.
+func (tr *Tracker) Add(bytes, hashed, transferred int64) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.files++
+	tr.bytes += bytes
+	tr.hashed += hashed
+	tr.uploaded += transferred
+}
+
+// Printf writes a line of output without clobbering the status line.
+func (tr *Tracker) Printf(format string, args ...any) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.clear()
+	fmt.Fprintf(tr.out, format, args...)
+	if tr.tty && tr.done != nil {
+		tr.draw()
+	}
+}
+
+func (tr *Tracker) run(quit, done chan struct{}) {
+	defer close(done)
+
+	interval := LogInterval
+	if tr.tty {
+		interval = TTYInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			tr.mu.Lock()
+			if tr.tty {
+				tr.draw()
+			} else {
+				fmt.Fprintf(tr.out, "progress: %s\n", tr.status())
+			}
+			tr.mu.Unlock()
+		}
+	}
+}
+
+// draw and clear must be called with the lock held
+func (tr *Tracker) draw() {
+	line := tr.status()
+	fmt.Fprintf(tr.out, "\r%s", line)
+	tr.statusLen = len(line)
+}
+
+func (tr *Tracker) clear() {
+	if tr.statusLen == 0 {
+		return
+	}
+	fmt.Fprintf(tr.out, "\r\033[K")
+	tr.statusLen = 0
+}
+
+func (tr *Tracker) status() string {
+	elapsed := time.Since(tr.start).Seconds()
+	if elapsed <= 0 {
+		elapsed = 1
+	}
+
+	files := humanize.Comma(tr.files)
+	if tr.totalFiles > 0 {
+		files = fmt.Sprintf("%s/%s", files, humanize.Comma(tr.totalFiles))
+	}
+	bytes := humanize.IBytes(uint64(tr.bytes))
+	if tr.totalBytes > 0 {
+		bytes = fmt.Sprintf("%s/%s", bytes, humanize.IBytes(uint64(tr.totalBytes)))
+	}
+
+	line := fmt.Sprintf("%s: files %s, bytes %s, hash %s/s, transfer %s/s",
+		tr.name,
+		files,
+		bytes,
+		humanize.IBytes(uint64(float64(tr.hashed)/elapsed)),
+		humanize.IBytes(uint64(float64(tr.uploaded)/elapsed)),
+	)
+
+	if eta, ok := tr.eta(elapsed); ok {
+		line += fmt.Sprintf(", eta %s", eta)
+	}
+
+	return line
+}
+
+// eta estimates the remaining time from the fraction of the bytes processed,
+// falling back to the fraction of files if the byte totals aren't known.
+func (tr *Tracker) eta(elapsed float64) (time.Duration, bool) {
+	var fraction float64
+	switch {
+	case tr.totalBytes > 0 && tr.bytes > 0:
+		fraction = float64(tr.bytes) / float64(tr.totalBytes)
+	case tr.totalFiles > 0 && tr.files > 0:
+		fraction = float64(tr.files) / float64(tr.totalFiles)
+	default:
+		return 0, false
+	}
+	if fraction >= 1 {
+		return 0, true
+	}
+
+	remaining := elapsed/fraction - elapsed
+	return (time.Duration(remaining) * time.Second).Round(time.Second), true
+}
@@ -0,0 +1,62 @@
+package progress_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/progress"
+)
+
+func TestTracker(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "progress.log"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	tr := progress.NewTracker(f, true)
+
+	// a run can be stopped from another goroutine, such as a signal handler, while
+	//   the workers are still reporting
+	for j := 0; j < 50; j++ {
+		tr.Start(fmt.Sprintf("run-%d", j))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tr.Add(10, 10, 5)
+				tr.Printf("run %d worker %d\n", j, i)
+				tr.Stop()
+			}(i)
+		}
+		wg.Wait()
+	}
+	tr.Stop()
+
+	data, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.Contains(t, string(data), fmt.Sprintf("run 49 worker %d\n", i))
+	}
+}
+
+func TestTrackerDisabled(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "progress.log"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	tr := progress.NewTracker(f, false)
+	tr.Start("run")
+	tr.Printf("hello\n")
+	tr.Stop()
+	tr.Stop()
+
+	data, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(data))
+}
@@ -0,0 +1,196 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/ops"
+)
+
+// The exit codes for the tools. A partial failure means the run completed
+// but some files couldn't be processed; a fatal error means a source or the
+// whole run couldn't be completed.
+const (
+	ExitSuccess = 0
+	ExitFatal   = 1
+	ExitPartial = 2
+)
+
+type Result string
+
+const (
+	ResultSuccess Result = "success"
+	ResultPartial Result = "partial"
+	ResultFatal   Result = "fatal"
+)
+
+type Failure struct {
+	Path    string `json:"path"`
+	Message string `json:"message,omitempty"`
+}
+
+// Source is the report for processing a single backup source or a
+// restore from a single manifest.
+type Source struct {
+	Job              string         `json:"job,omitempty"`
+	Label            string         `json:"label,omitempty"`
+	Path             string         `json:"path,omitempty"`
+	Manifest         string         `json:"manifest,omitempty"`
+	Start            time.Time      `json:"start"`
+	Duration         float64        `json:"duration_seconds"`
+	Result           Result         `json:"result"`
+	Files            int            `json:"files"`
+	Bytes            int64          `json:"bytes"`
+	BytesTransferred int64          `json:"bytes_transferred"`
+	EstimatedUpload  int64          `json:"estimated_upload,omitempty"`
+	Status           map[string]int `json:"status"`
+	Actions          map[string]int `json:"actions"`
+	Failed           []Failure      `json:"failed,omitempty"`
+	Error            string         `json:"error,omitempty"`
+}
+
+func NewSource(job, label, path string) *Source {
+	src := Source{
+		Job:     job,
+		Label:   label,
+		Path:    path,
+		Start:   time.Now(),
+		Status:  make(map[string]int),
+		Actions: make(map[string]int),
+	}
+	return &src
+}
+
+// Add tallies an entry into the report. The transferred argument is the
+// number of bytes uploaded or downloaded for the entry.
+func (src *Source) Add(ei *ops.EntryInfo, transferred int64) {
+	src.Files++
+	src.Status[ei.Status.String()]++
+	src.Actions[ei.Action.String()]++
+
+	if ei.Status != ops.StatusNotFound {
+		src.Bytes += ei.RawSize
+	}
+	src.BytesTransferred += transferred
+
+	if ei.Action == ops.Failed {
+		src.Failed = append(src.Failed, Failure{
+			Path:    ei.RelPath,
+			Message: ei.ActionMessage,
+		})
+	}
+}
+
+// Finish completes the report, recording the error if there was one.
+func (src *Source) Finish(err error) {
+	src.Duration = time.Since(src.Start).Seconds()
+
+	switch {
+	case err != nil:
+		src.Error = err.Error()
+		src.Result = ResultFatal
+	case len(src.Failed) > 0:
+		src.Result = ResultPartial
+	default:
+		src.Result = ResultSuccess
+	}
+}
+
+// Run is the report for a complete run of one of the tools.
+type Run struct {
+	Command  string    `json:"command"`
+	Bucket   string    `json:"bucket"`
+	Job      string    `json:"job,omitempty"`
+	DryRun   bool      `json:"dry_run,omitempty"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration_seconds"`
+	Result   Result    `json:"result"`
+	Sources  []*Source `json:"sources"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func NewRun(command, bucket, job string) *Run {
+	run := Run{
+		Command: command,
+		Bucket:  bucket,
+		Job:     job,
+		Start:   time.Now(),
+		Sources: []*Source{},
+	}
+	return &run
+}
+
+func (run *Run) Add(src *Source) {
+	run.Sources = append(run.Sources, src)
+}
+
+// Fail records an error that stopped the run as a whole. Only the first
+// error is kept.
+func (run *Run) Fail(err error) {
+	if run.Error == "" {
+		run.Error = err.Error()
+	}
+}
+
+// Finish completes the report. The result of the run is the worst result
+// of all the sources, or fatal if the run failed as a whole.
+func (run *Run) Finish() {
+	run.Duration = time.Since(run.Start).Seconds()
+
+	if run.Error != "" {
+		run.Result = ResultFatal
+		return
+	}
+
+	run.Result = ResultSuccess
+	for _, src := range run.Sources {
+		if src.Result == ResultFatal {
+			run.Result = ResultFatal
+			break
+		}
+		if src.Result == ResultPartial {
+			run.Result = ResultPartial
+		}
+	}
+}
+
+func (run *Run) ExitCode() int {
+	switch run.Result {
+	case ResultFatal:
+		return ExitFatal
+	case ResultPartial:
+		return ExitPartial
+	}
+	return ExitSuccess
+}
+
+func (run *Run) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(run)
+}
+
+// WriteFile writes the report to a temporary file and renames it into place
+// so readers never see a partial report.
+func (run *Run) WriteFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".report-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = run.Write(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,70 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/report"
+)
+
+func TestEmptyRunIsSuccess(t *testing.T) {
+	run := report.NewRun("s3backup", "bucket", "job")
+	run.Finish()
+
+	require.Equal(t, report.ResultSuccess, run.Result)
+	require.Equal(t, report.ExitSuccess, run.ExitCode())
+}
+
+func TestFailedEntryIsPartial(t *testing.T) {
+	run := report.NewRun("s3backup", "bucket", "job")
+
+	src := report.NewSource("job", "label", "/tmp")
+	src.Add(&ops.EntryInfo{Status: ops.StatusNew, RelPath: "a", RawSize: 10, Action: ops.Uploaded}, 12)
+	src.Add(&ops.EntryInfo{Status: ops.StatusNew, RelPath: "b", RawSize: 20, Action: ops.Failed, ActionMessage: "oops"}, 0)
+	src.Add(&ops.EntryInfo{Status: ops.StatusNotFound, RelPath: "c", RawSize: 30}, 0)
+	src.Finish(nil)
+	run.Add(src)
+	run.Finish()
+
+	require.Equal(t, 3, src.Files)
+	require.Equal(t, int64(30), src.Bytes)
+	require.Equal(t, int64(12), src.BytesTransferred)
+	require.Equal(t, 2, src.Status["new"])
+	require.Equal(t, 1, src.Status["not_found"])
+	require.Equal(t, 1, src.Actions["failed"])
+	require.Equal(t, []report.Failure{{Path: "b", Message: "oops"}}, src.Failed)
+
+	require.Equal(t, report.ResultPartial, run.Result)
+	require.Equal(t, report.ExitPartial, run.ExitCode())
+}
+
+func TestSourceErrorIsFatal(t *testing.T) {
+	run := report.NewRun("s3backup", "bucket", "job")
+
+	ok := report.NewSource("job", "ok", "/tmp")
+	ok.Finish(nil)
+	run.Add(ok)
+
+	bad := report.NewSource("job", "bad", "/tmp")
+	bad.Finish(errors.New("failed to stat source"))
+	run.Add(bad)
+
+	run.Finish()
+
+	require.Equal(t, report.ResultFatal, run.Result)
+	require.Equal(t, report.ExitFatal, run.ExitCode())
+
+	// and check it survives a round trip
+	buffer := bytes.NewBuffer(nil)
+	require.NoError(t, run.Write(buffer))
+
+	var decoded report.Run
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &decoded))
+	require.Len(t, decoded.Sources, 2)
+	require.Equal(t, "failed to stat source", decoded.Sources[1].Error)
+}
@@ -0,0 +1,137 @@
+package restore
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// The archive formats that can be written.
+const (
+	FormatTar     = "tar"
+	FormatTarGzip = "tar.gz"
+	FormatTarZstd = "tar.zst"
+	FormatZip     = "zip"
+)
+
+// FormatFromName returns the archive format for the file name's extension, or
+// an empty string if it isn't recognised.
+func FormatFromName(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return FormatTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGzip
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return FormatTarZstd
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip
+	}
+	return ""
+}
+
+// Archive streams restored files into a tar or zip archive without staging them
+// on disk. The entries carry the modes and modification times from the manifest.
+// As the data is written as it's downloaded, a failed download leaves the archive
+// incomplete and it should be discarded.
+type Archive struct {
+	tw *tar.Writer
+	zw *zip.Writer
+
+	// the compression stage for tar, if any
+	compressor io.WriteCloser
+}
+
+// NewArchive creates an archive of the format writing to out.
+func NewArchive(out io.Writer, format string) (*Archive, error) {
+	var a Archive
+
+	switch format {
+	case FormatTar:
+		a.tw = tar.NewWriter(out)
+
+	case FormatTarGzip:
+		a.compressor = gzip.NewWriter(out)
+		a.tw = tar.NewWriter(a.compressor)
+
+	case FormatTarZstd:
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			return nil, err
+		}
+		a.compressor = zw
+		a.tw = tar.NewWriter(a.compressor)
+
+	case FormatZip:
+		a.zw = zip.NewWriter(out)
+
+	default:
+		return nil, fmt.Errorf("unknown archive format: %s", format)
+	}
+
+	return &a, nil
+}
+
+// Add downloads the entry's content into the archive under the name, verifying
+// it against the hash. It returns the number of bytes written.
+func (a *Archive) Add(client s3io.Client, info *ops.EntryInfo, name string) (int64, error) {
+	key := fmt.Sprintf("data/%s/%s", info.Hash[:4], info.Hash)
+	mtime := time.Unix(info.ModTime, 0)
+
+	var sink io.Writer
+	if a.tw != nil {
+		err := a.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     info.RawSize,
+			Mode:     int64(info.Mode.Perm()),
+			ModTime:  mtime,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return 0, err
+		}
+		sink = a.tw
+	} else {
+		hdr := zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: mtime,
+		}
+		hdr.SetMode(info.Mode)
+
+		w, err := a.zw.CreateHeader(&hdr)
+		if err != nil {
+			return 0, err
+		}
+		sink = w
+	}
+
+	return s3io.DownloadVerified(client, key, info.Hash, sink)
+}
+
+// Close finishes the archive.
+func (a *Archive) Close() error {
+	var err error
+	if a.tw != nil {
+		err = a.tw.Close()
+	} else {
+		err = a.zw.Close()
+	}
+
+	if a.compressor != nil {
+		if cerr := a.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
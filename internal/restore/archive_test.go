@@ -0,0 +1,104 @@
+package restore_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/restore"
+)
+
+func writeArchive(t *testing.T, format string) []byte {
+	fc := &fakeClient{
+		data: map[string]string{
+			keyOf("first"):  "first",
+			keyOf("second"): "second",
+		},
+		downloads: make(map[string]int),
+	}
+
+	var buf bytes.Buffer
+	archive, err := restore.NewArchive(&buf, format)
+	require.NoError(t, err)
+
+	_, err = archive.Add(fc, &ops.EntryInfo{RelPath: "a.txt", Hash: hashOf("first"), RawSize: 5, ModTime: 1700000000, Mode: 0640}, "x/a.txt")
+	require.NoError(t, err)
+	_, err = archive.Add(fc, &ops.EntryInfo{RelPath: "b.txt", Hash: hashOf("second"), RawSize: 6, ModTime: 1700000100, Mode: 0755}, "b.txt")
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+
+	return buf.Bytes()
+}
+
+func checkTar(t *testing.T, r io.Reader) {
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "x/a.txt", hdr.Name)
+	require.Equal(t, int64(0640), hdr.Mode)
+	require.Equal(t, int64(1700000000), hdr.ModTime.Unix())
+	data, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(data))
+
+	hdr, err = tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "b.txt", hdr.Name)
+	require.Equal(t, int64(0755), hdr.Mode)
+
+	_, err = tr.Next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestTarArchive(t *testing.T) {
+	checkTar(t, bytes.NewReader(writeArchive(t, restore.FormatTar)))
+}
+
+func TestTarGzipArchive(t *testing.T) {
+	gz, err := gzip.NewReader(bytes.NewReader(writeArchive(t, restore.FormatTarGzip)))
+	require.NoError(t, err)
+	checkTar(t, gz)
+}
+
+func TestTarZstdArchive(t *testing.T) {
+	zr, err := zstd.NewReader(bytes.NewReader(writeArchive(t, restore.FormatTarZstd)))
+	require.NoError(t, err)
+	defer zr.Close()
+	checkTar(t, zr)
+}
+
+func TestZipArchive(t *testing.T) {
+	data := writeArchive(t, restore.FormatZip)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 2)
+
+	f := zr.File[0]
+	require.Equal(t, "x/a.txt", f.Name)
+	require.Equal(t, os.FileMode(0640), f.Mode().Perm())
+	require.Equal(t, int64(1700000000), f.Modified.Unix())
+
+	rc, err := f.Open()
+	require.NoError(t, err)
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(content))
+}
+
+func TestFormatFromName(t *testing.T) {
+	require.Equal(t, restore.FormatTarGzip, restore.FormatFromName("snapshot.tgz"))
+	require.Equal(t, restore.FormatTarZstd, restore.FormatFromName("snapshot.tar.zst"))
+	require.Equal(t, restore.FormatZip, restore.FormatFromName("snapshot.zip"))
+	require.Equal(t, "", restore.FormatFromName("snapshot"))
+}
@@ -0,0 +1,92 @@
+package restore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/studio1767/s3backup/internal/ops"
+)
+
+// Policy is what to do when a file being restored already exists.
+type Policy string
+
+const (
+	PolicySkip        Policy = "skip"
+	PolicyOverwrite   Policy = "overwrite"
+	PolicyIfDifferent Policy = "overwrite-if-different"
+	PolicyKeepNewer   Policy = "keep-newer"
+	PolicyRename      Policy = "rename"
+)
+
+func ParsePolicy(name string) (Policy, error) {
+	switch p := Policy(name); p {
+	case PolicySkip, PolicyOverwrite, PolicyIfDifferent, PolicyKeepNewer, PolicyRename:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy: %s", name)
+}
+
+// Resolve applies the policy to the file at the path, returning true if the
+// entry should be restored over it. With PolicyRename, the existing file is
+// first renamed out of the way with a '.~N~' suffix.
+func Resolve(policy Policy, info *ops.EntryInfo, fpath string) (bool, error) {
+	fi, err := os.Lstat(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if fi.IsDir() {
+		return false, fmt.Errorf("a directory is in the way: %s", fpath)
+	}
+
+	switch policy {
+	case PolicyOverwrite:
+		return true, nil
+
+	case PolicyIfDifferent:
+		return differs(info, fpath, fi)
+
+	case PolicyKeepNewer:
+		return fi.ModTime().Unix() <= info.ModTime, nil
+
+	case PolicyRename:
+		for n := 1; ; n++ {
+			backup := fmt.Sprintf("%s.~%d~", fpath, n)
+			if _, err := os.Lstat(backup); os.IsNotExist(err) {
+				return true, os.Rename(fpath, backup)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// differs reports if the file differs from the entry. The size and modification
+// time are checked first, and only if the times differ is the content hashed.
+func differs(info *ops.EntryInfo, fpath string, fi os.FileInfo) (bool, error) {
+	if fi.Mode().IsRegular() == false || fi.Size() != info.RawSize {
+		return true, nil
+	}
+	if fi.ModTime().Unix() == info.ModTime {
+		return false, nil
+	}
+
+	in, err := os.Open(fpath)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, in)
+	if err != nil {
+		return false, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) != info.Hash, nil
+}
@@ -0,0 +1,160 @@
+package restore_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/restore"
+)
+
+func existing(t *testing.T, content string, mtime int64) string {
+	fpath := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(fpath, []byte(content), 0644))
+	require.NoError(t, os.Chtimes(fpath, time.Unix(mtime, 0), time.Unix(mtime, 0)))
+	return fpath
+}
+
+func TestResolvePolicies(t *testing.T) {
+	info := &ops.EntryInfo{RelPath: "file.txt", Hash: hashOf("first"), RawSize: 5, ModTime: 1700000000}
+
+	// nothing in the way
+	ok, err := restore.Resolve(restore.PolicySkip, info, filepath.Join(t.TempDir(), "missing.txt"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = restore.Resolve(restore.PolicySkip, info, existing(t, "other", 1700000000))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = restore.Resolve(restore.PolicyOverwrite, info, existing(t, "first", 1700000000))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// same size and time are taken as the same; the content is only hashed if the times differ
+	ok, err = restore.Resolve(restore.PolicyIfDifferent, info, existing(t, "first", 1700000000))
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = restore.Resolve(restore.PolicyIfDifferent, info, existing(t, "first", 1700000500))
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = restore.Resolve(restore.PolicyIfDifferent, info, existing(t, "other", 1700000500))
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = restore.Resolve(restore.PolicyIfDifferent, info, existing(t, "longer", 1700000000))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = restore.Resolve(restore.PolicyKeepNewer, info, existing(t, "other", 1700000500))
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = restore.Resolve(restore.PolicyKeepNewer, info, existing(t, "other", 1600000000))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestResolveRename(t *testing.T) {
+	info := &ops.EntryInfo{RelPath: "file.txt", Hash: hashOf("first"), RawSize: 5, ModTime: 1700000000}
+
+	fpath := existing(t, "other", 1700000000)
+	require.NoError(t, os.WriteFile(fpath+".~1~", []byte("older"), 0644))
+
+	ok, err := restore.Resolve(restore.PolicyRename, info, fpath)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	data, err := os.ReadFile(fpath + ".~2~")
+	require.NoError(t, err)
+	require.Equal(t, "other", string(data))
+	require.NoFileExists(t, fpath)
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := restore.ParsePolicy("keep-newer")
+	require.NoError(t, err)
+	require.Equal(t, restore.PolicyKeepNewer, p)
+
+	_, err = restore.ParsePolicy("merge")
+	require.Error(t, err)
+}
+
+func TestSyncExtras(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{"keep.txt", "extra.txt", "dir/keep.txt", "old/a.txt", "old/deep/b.txt", ".git/config"} {
+		fpath := filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0755))
+		require.NoError(t, os.WriteFile(fpath, []byte(path), 0644))
+	}
+
+	sel, err := restore.NewSelector(".*", nil, []string{".git"})
+	require.NoError(t, err)
+
+	wanted := map[string]bool{"keep.txt": true, "dir/keep.txt": true}
+	extras, err := restore.FindExtras(root, func(relpath string) bool {
+		return wanted[relpath] || !restore.InSync(sel, nil, relpath)
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"extra.txt", "old/a.txt", "old/deep/b.txt"}, extras)
+
+	require.NoError(t, restore.RemoveExtras(root, extras))
+	require.NoFileExists(t, filepath.Join(root, "extra.txt"))
+	require.NoDirExists(t, filepath.Join(root, "old"))
+	require.FileExists(t, filepath.Join(root, "dir/keep.txt"))
+	require.FileExists(t, filepath.Join(root, ".git/config"))
+}
+
+func syncRoot(t *testing.T, paths ...string) string {
+	root := t.TempDir()
+	for _, path := range paths {
+		fpath := filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0755))
+		require.NoError(t, os.WriteFile(fpath, []byte(path), 0644))
+	}
+	return root
+}
+
+func TestSyncWithInclude(t *testing.T) {
+	root := syncRoot(t, "Documents/a.txt", "Documents/old.txt", "Pictures/x.jpg", "notes.txt")
+
+	// only the included subtree is synced; everything else in the root is kept
+	sel, err := restore.NewSelector(".*", []string{"Documents"}, nil)
+	require.NoError(t, err)
+
+	wanted := map[string]bool{"Documents/a.txt": true}
+	extras, err := restore.FindExtras(root, func(relpath string) bool {
+		return wanted[relpath] || !restore.InSync(sel, nil, relpath)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Documents/old.txt"}, extras)
+
+	// and the same for a pattern
+	sel, err = restore.NewSelector(`\.jpg$`, nil, nil)
+	require.NoError(t, err)
+
+	extras, err = restore.FindExtras(root, func(relpath string) bool {
+		return !restore.InSync(sel, nil, relpath)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Pictures/x.jpg"}, extras)
+}
+
+func TestSyncExcludesRenamed(t *testing.T) {
+	root := syncRoot(t, "src/keep/a.txt", "src/extra.txt", "keep/b.txt")
+
+	// the exclude is for the manifest path, so protects the renamed files under
+	//   src/keep, not the ones under keep
+	sel, err := restore.NewSelector(".*", nil, []string{"Projects/keep"})
+	require.NoError(t, err)
+	mapper, err := restore.NewMapper(0, []string{"Projects=src"})
+	require.NoError(t, err)
+
+	extras, err := restore.FindExtras(root, func(relpath string) bool {
+		return !restore.InSync(sel, mapper, relpath)
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"src/extra.txt", "keep/b.txt"}, extras)
+}
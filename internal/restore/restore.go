@@ -0,0 +1,248 @@
+// Package restore restores the files in a manifest from the bucket, downloading
+// each unique content hash once.
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// DefaultWorkers is the number of concurrent downloads if none is given.
+const DefaultWorkers = 4
+
+// Result is the outcome of restoring a single file. Transferred is the size of
+// the content downloaded for it, which is zero for files copied or linked from
+// another file with the same content.
+type Result struct {
+	Info        *ops.EntryInfo
+	Path        string
+	Transferred int64
+	Err         error
+}
+
+// Engine restores files with a pool of workers. The files with the same content
+// are grouped so the content is downloaded once, to the first file, and then
+// copied to the others, or hardlinked if link is set and the modes and times match.
+type Engine struct {
+	client  s3io.Client
+	root    string
+	workers int
+	link    bool
+	mapper  *Mapper
+}
+
+func New(client s3io.Client, root string, workers int, link bool) *Engine {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	e := Engine{
+		client:  client,
+		root:    root,
+		workers: workers,
+		link:    link,
+	}
+	return &e
+}
+
+// SetMapper sets the mapping from the manifest paths to the paths to restore to.
+// Entries the mapper drops must not be passed to Restore.
+func (e *Engine) SetMapper(mapper *Mapper) {
+	e.mapper = mapper
+}
+
+// Restore restores the entries into the root. All the entries are read before the
+// downloads start so the duplicates can be found. The results are returned in
+// the order the files complete, and the channel is closed when all are done.
+func (e *Engine) Restore(ctx context.Context, in <-chan *ops.EntryInfo) <-chan *Result {
+	out := make(chan *Result, 10)
+
+	go func() {
+		defer close(out)
+
+		// group the entries by their content, keeping the manifest order
+		var groups [][]*ops.EntryInfo
+		index := make(map[string]int)
+		for info := range in {
+			idx, ok := index[info.Hash]
+			if !ok {
+				idx = len(groups)
+				index[info.Hash] = idx
+				groups = append(groups, nil)
+			}
+			groups[idx] = append(groups[idx], info)
+		}
+
+		// and hand them out to the workers
+		work := make(chan []*ops.EntryInfo)
+
+		var wg sync.WaitGroup
+		for i := 0; i < e.workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for group := range work {
+					e.restoreGroup(group, out)
+				}
+			}()
+		}
+
+	feed:
+		for _, group := range groups {
+			select {
+			case <-ctx.Done():
+				break feed
+			case work <- group:
+			}
+		}
+		close(work)
+
+		wg.Wait()
+	}()
+
+	return out
+}
+
+// restoreGroup downloads the content to the first file that succeeds and then
+// copies or links it to the rest.
+func (e *Engine) restoreGroup(group []*ops.EntryInfo, out chan<- *Result) {
+	var source *ops.EntryInfo
+	var source_path string
+
+	for _, info := range group {
+		dest, _ := e.mapper.Map(info.RelPath)
+		fpath := filepath.Join(e.root, dest)
+
+		var size int64
+		var err error
+		if source == nil {
+			size, err = e.download(info, fpath)
+			if err == nil {
+				source = info
+				source_path = fpath
+			}
+		} else {
+			err = e.duplicate(source, source_path, info, fpath)
+		}
+
+		out <- &Result{
+			Info:        info,
+			Path:        fpath,
+			Transferred: size,
+			Err:         err,
+		}
+	}
+}
+
+func (e *Engine) download(info *ops.EntryInfo, fpath string) (int64, error) {
+	return File(e.client, info, fpath)
+}
+
+// File restores a single entry to the path, replacing anything already there. It
+// returns the number of bytes downloaded.
+func File(client s3io.Client, info *ops.EntryInfo, fpath string) (int64, error) {
+	// construct the key from the hash
+	key := fmt.Sprintf("data/%s/%s", info.Hash[:4], info.Hash)
+
+	// the content is verified against the hash before it's renamed into place
+	return writeFile(fpath, info, func(sink io.Writer) (int64, error) {
+		return s3io.DownloadVerified(client, key, info.Hash, sink)
+	})
+}
+
+// duplicate copies the already restored source file to the path, or links it if
+// allowed. Hardlinks share the file mode and times, so are only used if they match.
+func (e *Engine) duplicate(source *ops.EntryInfo, source_path string, info *ops.EntryInfo, fpath string) error {
+	if e.link && source.Mode == info.Mode && source.ModTime == info.ModTime {
+		err := linkFile(source_path, fpath)
+		if err == nil {
+			return nil
+		}
+		// fall back to copying, e.g. across filesystems
+	}
+
+	in, err := os.Open(source_path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	_, err = writeFile(fpath, info, func(sink io.Writer) (int64, error) {
+		return io.Copy(sink, in)
+	})
+	return err
+}
+
+// writeFile writes the file to a temporary name in the same directory, syncs it,
+// sets the mode and renames it into place with its modification time from the
+// manifest, so a failure never leaves a partial file at the path.
+func writeFile(fpath string, info *ops.EntryInfo, fill func(io.Writer) (int64, error)) (int64, error) {
+	// create the directories to the file
+	fdir := filepath.Dir(fpath)
+	err := os.MkdirAll(fdir, 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	sink, err := os.CreateTemp(fdir, "."+filepath.Base(fpath)+".s3bu-*")
+	if err != nil {
+		return 0, err
+	}
+	tmpname := sink.Name()
+
+	size, err := fill(sink)
+	if err == nil {
+		err = sink.Sync()
+	}
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpname, info.Mode)
+	}
+	if err == nil {
+		err = os.Rename(tmpname, fpath)
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return 0, err
+	}
+
+	return size, setModTime(fpath, info)
+}
+
+// linkFile hardlinks the source to the path, replacing anything already there.
+func linkFile(source_path, fpath string) error {
+	fdir := filepath.Dir(fpath)
+	err := os.MkdirAll(fdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// link to a temporary name and rename it over the path
+	tmpname := filepath.Join(fdir, fmt.Sprintf(".%s.s3bu-link", filepath.Base(fpath)))
+	os.Remove(tmpname)
+
+	err = os.Link(source_path, tmpname)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpname, fpath)
+	if err != nil {
+		os.Remove(tmpname)
+	}
+	return err
+}
+
+func setModTime(fpath string, info *ops.EntryInfo) error {
+	mtime := time.Unix(info.ModTime, 0)
+	return os.Chtimes(fpath, mtime, mtime)
+}
@@ -0,0 +1,181 @@
+package restore_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/ops"
+	"github.com/studio1767/s3backup/internal/restore"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// fakeClient serves the data keys from memory and counts the downloads.
+type fakeClient struct {
+	s3io.Client
+
+	mu        sync.Mutex
+	data      map[string]string
+	downloads map[string]int
+	fail      bool
+}
+
+func (fc *fakeClient) Download(key string, sink io.Writer) (int64, error) {
+	fc.mu.Lock()
+	fc.downloads[key]++
+	fc.mu.Unlock()
+
+	nbytes, err := io.Copy(sink, strings.NewReader(fc.data[key]))
+	if fc.fail {
+		return nbytes, fmt.Errorf("connection reset")
+	}
+	return nbytes, err
+}
+
+func hashOf(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(h[:])
+}
+
+func keyOf(content string) string {
+	hash := hashOf(content)
+	return "data/" + hash[:4] + "/" + hash
+}
+
+func restoreAll(t *testing.T, link bool) (string, *fakeClient, []*restore.Result) {
+	fc := &fakeClient{
+		data: map[string]string{
+			keyOf("first"):  "first",
+			keyOf("second"): "second",
+		},
+		downloads: make(map[string]int),
+	}
+
+	entries := []*ops.EntryInfo{
+		{RelPath: "one.txt", Hash: hashOf("first"), RawSize: 5, ModTime: 1700000000, Mode: 0644},
+		{RelPath: "dir/two.txt", Hash: hashOf("second"), RawSize: 6, ModTime: 1700000100, Mode: 0644},
+		{RelPath: "dir/sub/three.txt", Hash: hashOf("first"), RawSize: 5, ModTime: 1700000000, Mode: 0644},
+		{RelPath: "four.txt", Hash: hashOf("first"), RawSize: 5, ModTime: 1700000200, Mode: 0600},
+	}
+	in := make(chan *ops.EntryInfo, len(entries))
+	for _, ei := range entries {
+		in <- ei
+	}
+	close(in)
+
+	root := t.TempDir()
+	engine := restore.New(fc, root, 2, link)
+
+	var results []*restore.Result
+	for result := range engine.Restore(context.Background(), in) {
+		results = append(results, result)
+	}
+
+	return root, fc, results
+}
+
+func TestRestoreDownloadsEachHashOnce(t *testing.T) {
+	root, fc, results := restoreAll(t, false)
+
+	require.Len(t, results, 4)
+	var transferred int64
+	for _, result := range results {
+		require.NoError(t, result.Err)
+		transferred += result.Transferred
+	}
+	require.Equal(t, int64(11), transferred)
+	require.Equal(t, map[string]int{keyOf("first"): 1, keyOf("second"): 1}, fc.downloads)
+
+	for path, content := range map[string]string{
+		"one.txt":           "first",
+		"dir/two.txt":       "second",
+		"dir/sub/three.txt": "first",
+		"four.txt":          "first",
+	} {
+		data, err := os.ReadFile(filepath.Join(root, path))
+		require.NoError(t, err)
+		require.Equal(t, content, string(data))
+	}
+
+	fi, err := os.Stat(filepath.Join(root, "four.txt"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	require.Equal(t, int64(1700000200), fi.ModTime().Unix())
+
+	fi, err = os.Stat(filepath.Join(root, "dir/two.txt"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1700000100), fi.ModTime().Unix())
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(root, "dir"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestFailedRestoreLeavesNoFile(t *testing.T) {
+	fc := &fakeClient{
+		data:      map[string]string{},
+		downloads: make(map[string]int),
+		fail:      true,
+	}
+
+	in := make(chan *ops.EntryInfo, 1)
+	in <- &ops.EntryInfo{RelPath: "one.txt", Hash: hashOf("first"), RawSize: 5, Mode: 0644}
+	close(in)
+
+	root := t.TempDir()
+	for result := range restore.New(fc, root, 1, false).Restore(context.Background(), in) {
+		require.Error(t, result.Err)
+	}
+
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestRestoreLinksDuplicates(t *testing.T) {
+	root, _, _ := restoreAll(t, true)
+
+	one, err := os.Stat(filepath.Join(root, "one.txt"))
+	require.NoError(t, err)
+	three, err := os.Stat(filepath.Join(root, "dir/sub/three.txt"))
+	require.NoError(t, err)
+	four, err := os.Stat(filepath.Join(root, "four.txt"))
+	require.NoError(t, err)
+
+	require.True(t, os.SameFile(one, three))
+
+	// different modes can't share a link
+	require.False(t, os.SameFile(one, four))
+	require.Equal(t, os.FileMode(0600), four.Mode().Perm())
+}
+
+func TestRestoreRejectsBadContent(t *testing.T) {
+	fc := &fakeClient{
+		data:      map[string]string{keyOf("first"): "corrupted"},
+		downloads: make(map[string]int),
+	}
+
+	in := make(chan *ops.EntryInfo, 1)
+	in <- &ops.EntryInfo{RelPath: "one.txt", Hash: hashOf("first"), RawSize: 5, Mode: 0644}
+	close(in)
+
+	root := t.TempDir()
+	for result := range restore.New(fc, root, 1, false).Restore(context.Background(), in) {
+		var mismatch *s3io.ErrHashMismatch
+		require.ErrorAs(t, result.Err, &mismatch)
+	}
+
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
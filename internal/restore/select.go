@@ -0,0 +1,246 @@
+package restore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Selector chooses the manifest entries to restore. An entry is selected if it
+// matches the pattern, is in the files list if there is one, matches one of the
+// include globs if there are any, and doesn't match any of the exclude globs.
+//
+// A glob containing a '/' is matched against the path and each of its parent
+// directories, so 'Projects/s3backup' selects everything under that directory.
+// Without a '/', it's matched against each name in the path, so '*.tmp' matches
+// those files anywhere and 'node_modules' everything under those directories.
+type Selector struct {
+	pattern  *regexp.Regexp
+	includes []string
+	excludes []string
+	files    map[string]bool
+}
+
+func NewSelector(pattern string, includes, excludes []string) (*Selector, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, globs := range [][]string{includes, excludes} {
+		for _, glob := range globs {
+			_, err := path.Match(glob, "")
+			if err != nil {
+				return nil, fmt.Errorf("bad glob %s: %w", glob, err)
+			}
+		}
+	}
+
+	sel := Selector{
+		pattern:  regex,
+		includes: includes,
+		excludes: excludes,
+	}
+	return &sel, nil
+}
+
+// LoadFiles reads the list of files to restore, one per line. Blank lines and
+// lines starting with '#' are skipped. A directory in the list selects everything
+// under it.
+func (sel *Selector) LoadFiles(files_from string) error {
+	f, err := os.Open(files_from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sel.files = make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sel.files[path.Clean(strings.TrimPrefix(line, "/"))] = true
+	}
+
+	return scanner.Err()
+}
+
+func (sel *Selector) Match(relpath string) bool {
+	if !sel.pattern.MatchString(relpath) {
+		return false
+	}
+
+	if sel.files != nil {
+		found := false
+		for p := relpath; p != "."; p = path.Dir(p) {
+			if sel.files[p] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(sel.includes) > 0 {
+		found := false
+		for _, glob := range sel.includes {
+			if matchGlob(glob, relpath) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return !sel.Excluded(relpath)
+}
+
+// Excluded reports if the path matches one of the exclude globs.
+func (sel *Selector) Excluded(relpath string) bool {
+	for _, glob := range sel.excludes {
+		if matchGlob(glob, relpath) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(glob, relpath string) bool {
+	if strings.Contains(glob, "/") {
+		glob = strings.TrimPrefix(glob, "/")
+		for p := relpath; p != "."; p = path.Dir(p) {
+			if ok, _ := path.Match(glob, p); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, name := range strings.Split(relpath, "/") {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Mapper maps the paths in the manifest to the paths to restore to. The leading
+// directories are stripped first, then the first matching prefix is renamed.
+type Mapper struct {
+	strip   int
+	renames []rename
+}
+
+type rename struct {
+	from string
+	to   string
+}
+
+// NewMapper creates a mapper that strips the number of leading directories and
+// applies the renames, each of the form 'old/prefix=new/prefix'. The new prefix
+// can be empty to remove the old one.
+func NewMapper(strip int, renames []string) (*Mapper, error) {
+	if strip < 0 {
+		return nil, fmt.Errorf("can't strip a negative number of components: %d", strip)
+	}
+
+	m := Mapper{
+		strip: strip,
+	}
+	for _, spec := range renames {
+		from, to, ok := strings.Cut(spec, "=")
+		if !ok || strings.Trim(from, "/") == "" {
+			return nil, fmt.Errorf("bad rename, expected old=new: %s", spec)
+		}
+		m.renames = append(m.renames, rename{
+			from: path.Clean(strings.Trim(from, "/")),
+			to:   strings.Trim(to, "/"),
+		})
+	}
+
+	return &m, nil
+}
+
+// Map returns the path to restore the manifest path to. It returns false if the
+// path has no components left after stripping, or would be outside the root.
+func (m *Mapper) Map(relpath string) (string, bool) {
+	if m == nil {
+		return relpath, true
+	}
+
+	if m.strip > 0 {
+		segments := strings.Split(relpath, "/")
+		if len(segments) <= m.strip {
+			return "", false
+		}
+		relpath = strings.Join(segments[m.strip:], "/")
+	}
+
+	for _, rn := range m.renames {
+		if relpath == rn.from {
+			relpath = rn.to
+			break
+		}
+		if strings.HasPrefix(relpath, rn.from+"/") {
+			relpath = path.Join(rn.to, strings.TrimPrefix(relpath, rn.from+"/"))
+			break
+		}
+	}
+
+	// never restore outside the restore root
+	relpath = path.Clean(relpath)
+	if relpath == "." || relpath == ".." || strings.HasPrefix(relpath, "../") {
+		return "", false
+	}
+	return relpath, true
+}
+
+// Unmap returns the manifest path that maps to the restore path, reversing the
+// renames. It returns false if leading directories are stripped, as they can't be
+// recovered, or if no manifest path maps to the restore path.
+func (m *Mapper) Unmap(relpath string) (string, bool) {
+	relpath = path.Clean(relpath)
+	if m == nil {
+		return relpath, true
+	}
+	if m.strip > 0 {
+		return "", false
+	}
+
+	// the candidates are the path through each rename, or the path as it is; the
+	//   first rename that matches wins, so each is checked by mapping it back
+	var candidates []string
+	for _, rn := range m.renames {
+		switch {
+		case rn.to == "":
+			candidates = append(candidates, path.Join(rn.from, relpath))
+		case relpath == rn.to:
+			candidates = append(candidates, rn.from)
+		case strings.HasPrefix(relpath, rn.to+"/"):
+			candidates = append(candidates, path.Join(rn.from, strings.TrimPrefix(relpath, rn.to+"/")))
+		}
+	}
+	candidates = append(candidates, relpath)
+
+	for _, candidate := range candidates {
+		if dest, ok := m.Map(candidate); ok && dest == relpath {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// Strips reports whether leading directories are stripped from the paths.
+func (m *Mapper) Strips() bool {
+	return m != nil && m.strip > 0
+}
@@ -0,0 +1,97 @@
+package restore_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/restore"
+)
+
+func TestSelectorGlobs(t *testing.T) {
+	sel, err := restore.NewSelector(".*", []string{"Projects/s3backup"}, []string{"*.tmp", "node_modules"})
+	require.NoError(t, err)
+
+	require.True(t, sel.Match("Projects/s3backup/main.go"))
+	require.True(t, sel.Match("Projects/s3backup/cmd/s3backup/s3backup.go"))
+	require.False(t, sel.Match("Projects/other/main.go"))
+	require.False(t, sel.Match("Projects/s3backup/build.tmp"))
+	require.False(t, sel.Match("Projects/s3backup/web/node_modules/x/index.js"))
+}
+
+func TestSelectorPatternAndFiles(t *testing.T) {
+	files := filepath.Join(t.TempDir(), "files.txt")
+	require.NoError(t, os.WriteFile(files, []byte("# files to restore\n\nDocuments/a.txt\n/Pictures/2023\n"), 0644))
+
+	sel, err := restore.NewSelector(`\.(txt|jpg)$`, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, sel.LoadFiles(files))
+
+	require.True(t, sel.Match("Documents/a.txt"))
+	require.False(t, sel.Match("Documents/b.txt"))
+	require.True(t, sel.Match("Pictures/2023/x.jpg"))
+	require.False(t, sel.Match("Pictures/2023/x.png"))
+	require.False(t, sel.Match("Pictures/2022/x.jpg"))
+}
+
+func TestBadSelectors(t *testing.T) {
+	_, err := restore.NewSelector("(", nil, nil)
+	require.Error(t, err)
+
+	_, err = restore.NewSelector(".*", []string{"[a-"}, nil)
+	require.Error(t, err)
+}
+
+func TestMapper(t *testing.T) {
+	m, err := restore.NewMapper(1, []string{"Projects/s3backup=src/s3backup", "tmp="})
+	require.NoError(t, err)
+
+	dest, ok := m.Map("home/Projects/s3backup/main.go")
+	require.True(t, ok)
+	require.Equal(t, "src/s3backup/main.go", dest)
+
+	dest, ok = m.Map("home/Projects/s3backupx/main.go")
+	require.True(t, ok)
+	require.Equal(t, "Projects/s3backupx/main.go", dest)
+
+	dest, ok = m.Map("home/tmp/a.txt")
+	require.True(t, ok)
+	require.Equal(t, "a.txt", dest)
+
+	_, ok = m.Map("toplevel.txt")
+	require.False(t, ok)
+
+	m, err = restore.NewMapper(0, []string{"a=../escape"})
+	require.NoError(t, err)
+	_, ok = m.Map("a/b.txt")
+	require.False(t, ok)
+
+	_, err = restore.NewMapper(0, []string{"noequals"})
+	require.Error(t, err)
+}
+
+func TestUnmap(t *testing.T) {
+	m, err := restore.NewMapper(0, []string{"Projects/s3backup=src/s3backup", "tmp="})
+	require.NoError(t, err)
+
+	for _, mpath := range []string{"Projects/s3backup/main.go", "Projects/other/main.go", "tmp/a.txt"} {
+		dest, ok := m.Map(mpath)
+		require.True(t, ok)
+		back, ok := m.Unmap(dest)
+		require.True(t, ok)
+		dest2, _ := m.Map(back)
+		require.Equal(t, dest, dest2)
+	}
+
+	back, ok := m.Unmap("src/s3backup/main.go")
+	require.True(t, ok)
+	require.Equal(t, "Projects/s3backup/main.go", back)
+
+	// stripped directories can't be recovered
+	m, err = restore.NewMapper(1, nil)
+	require.NoError(t, err)
+	_, ok = m.Unmap("a.txt")
+	require.False(t, ok)
+}
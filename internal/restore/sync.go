@@ -0,0 +1,75 @@
+package restore
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FindExtras walks the root and returns the paths, relative to the root, of the
+// files that aren't wanted. Directories are walked but never returned themselves.
+func FindExtras(root string, wanted func(relpath string) bool) ([]string, error) {
+	var extras []string
+
+	err := filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relpath, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+		if !wanted(relpath) {
+			extras = append(extras, relpath)
+		}
+		return nil
+	})
+
+	return extras, err
+}
+
+// InSync reports whether a file at the restore path that isn't being restored is
+// an extra file in sync mode. It is only if its path in the manifest would be
+// selected, so files outside the pattern, include globs or files list, and files
+// matching the exclude globs, are kept. Paths that can't be mapped back to the
+// manifest are kept too.
+func InSync(sel *Selector, mapper *Mapper, relpath string) bool {
+	mpath, ok := mapper.Unmap(filepath.ToSlash(relpath))
+	return ok && sel.Match(mpath)
+}
+
+// RemoveExtras deletes the files under the root and then any directories left
+// empty by their removal.
+func RemoveExtras(root string, extras []string) error {
+	dirs := make(map[string]bool)
+
+	for _, relpath := range extras {
+		err := os.Remove(filepath.Join(root, relpath))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for dir := filepath.Dir(relpath); dir != "."; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	// deepest first, so parents are emptied before they're tried; removing a
+	// directory that isn't empty fails, which is fine
+	var ordered []string
+	for dir := range dirs {
+		ordered = append(ordered, dir)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return len(ordered[i]) > len(ordered[j])
+	})
+	for _, dir := range ordered {
+		os.Remove(filepath.Join(root, dir))
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// Thawer gets archived objects thawed so they can be restored. It requests the
+// restores, then polls until the temporary copies are available. Thaws can take
+// hours or days, so the state of each object is kept in a file if one is given,
+// and a later run with the same file carries on from where it left off.
+//
+// The state file can hold objects from other restores; only the keys requested
+// by this run are polled and counted.
+type Thawer struct {
+	client     s3io.Client
+	tier       string
+	days       int
+	state_file string
+
+	state thawState
+	keys  map[string]bool
+}
+
+type thawState struct {
+	Objects map[string]*thawEntry `json:"objects"`
+}
+
+type thawEntry struct {
+	State string `json:"state"`
+
+	// when the object was first seen to be available
+	Available time.Time `json:"available,omitzero"`
+}
+
+// NewThawer creates a thawer requesting restores with the retrieval tier, keeping
+// the copies for the number of days. If the state file exists, it's loaded.
+func NewThawer(client s3io.Client, tier string, days int, state_file string) (*Thawer, error) {
+	t := Thawer{
+		client:     client,
+		tier:       tier,
+		days:       days,
+		state_file: state_file,
+		state: thawState{
+			Objects: make(map[string]*thawEntry),
+		},
+		keys: make(map[string]bool),
+	}
+
+	if state_file != "" {
+		data, err := os.ReadFile(state_file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			err := json.Unmarshal(data, &t.state)
+			if err != nil {
+				return nil, err
+			}
+			if t.state.Objects == nil {
+				t.state.Objects = make(map[string]*thawEntry)
+			}
+		}
+	}
+
+	return &t, nil
+}
+
+// Request checks each of the keys, requesting a thaw of those that are archived.
+// Keys already known to be thawing or available from an earlier run aren't checked
+// again, unless the copy may have expired since. It returns the number of objects
+// that are still thawing.
+func (t *Thawer) Request(keys []string) (int, error) {
+	for _, key := range keys {
+		t.keys[key] = true
+	}
+
+	for _, key := range keys {
+		entry := t.state.Objects[key]
+		if entry != nil && entry.State == s3io.Thawing.String() {
+			continue
+		}
+		if entry != nil && entry.State == s3io.Available.String() && !t.expired(entry) {
+			continue
+		}
+
+		state, err := t.client.Thaw(key, t.tier, t.days)
+		if err != nil {
+			t.save()
+			return t.pending(), err
+		}
+		t.set(key, state)
+	}
+
+	return t.pending(), t.save()
+}
+
+// Poll checks the requested objects that are thawing, returning the number still
+// thawing.
+// Any that have gone back to being archived, as their copy expired before it was
+// seen, are requested again.
+func (t *Thawer) Poll() (int, error) {
+	for key := range t.keys {
+		entry := t.state.Objects[key]
+		if entry == nil || entry.State == s3io.Available.String() {
+			continue
+		}
+
+		state, err := t.client.ArchiveState(key)
+		if err == nil && state == s3io.Archived {
+			state, err = t.client.Thaw(key, t.tier, t.days)
+		}
+		if err != nil {
+			t.save()
+			return t.pending(), err
+		}
+		t.set(key, state)
+	}
+
+	return t.pending(), t.save()
+}
+
+// Wait polls at the interval until all the objects are available, calling the
+// report function with the number still thawing after each poll.
+func (t *Thawer) Wait(ctx context.Context, interval time.Duration, report func(pending int)) error {
+	for {
+		pending, err := t.Poll()
+		if err != nil {
+			return err
+		}
+		if report != nil {
+			report(pending)
+		}
+		if pending == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Counts returns the number of requested objects in each state.
+func (t *Thawer) Counts() map[string]int {
+	counts := make(map[string]int)
+	for key := range t.keys {
+		if entry := t.state.Objects[key]; entry != nil {
+			counts[entry.State]++
+		}
+	}
+	return counts
+}
+
+func (t *Thawer) set(key string, state s3io.ArchiveState) {
+	entry := t.state.Objects[key]
+	if entry == nil {
+		entry = &thawEntry{}
+		t.state.Objects[key] = entry
+	}
+
+	if state == s3io.Available && entry.State != state.String() {
+		entry.Available = time.Now()
+	}
+	entry.State = state.String()
+}
+
+// expired reports if a thawed copy may have expired since it was seen
+func (t *Thawer) expired(entry *thawEntry) bool {
+	return time.Since(entry.Available) > time.Duration(t.days)*24*time.Hour
+}
+
+func (t *Thawer) pending() int {
+	pending := 0
+	for key := range t.keys {
+		entry := t.state.Objects[key]
+		if entry != nil && entry.State != s3io.Available.String() {
+			pending++
+		}
+	}
+	return pending
+}
+
+// save writes the state file, replacing the old one atomically so an interrupted
+// run never leaves it truncated
+func (t *Thawer) save() error {
+	if t.state_file == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(&t.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(t.state_file), "."+filepath.Base(t.state_file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), t.state_file)
+}
@@ -0,0 +1,157 @@
+package restore_test
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/restore"
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+// thawClient pretends to be a bucket of archived objects that become available
+// after a number of polls.
+type thawClient struct {
+	s3io.Client
+
+	states   map[string]s3io.ArchiveState
+	polls    map[string]int
+	requests map[string]int
+}
+
+func newThawClient(states map[string]s3io.ArchiveState) *thawClient {
+	return &thawClient{
+		states:   states,
+		polls:    make(map[string]int),
+		requests: make(map[string]int),
+	}
+}
+
+func (tc *thawClient) Thaw(key string, tier string, days int) (s3io.ArchiveState, error) {
+	if tc.states[key] == s3io.Archived {
+		tc.requests[key]++
+		tc.states[key] = s3io.Thawing
+	}
+	return tc.states[key], nil
+}
+
+func (tc *thawClient) ArchiveState(key string) (s3io.ArchiveState, error) {
+	tc.polls[key]++
+	if tc.states[key] == s3io.Thawing && tc.polls[key] >= 2 {
+		tc.states[key] = s3io.Available
+	}
+	return tc.states[key], nil
+}
+
+func TestThawWait(t *testing.T) {
+	tc := newThawClient(map[string]s3io.ArchiveState{
+		"data/aaaa/aaaa": s3io.Available,
+		"data/bbbb/bbbb": s3io.Archived,
+		"data/cccc/cccc": s3io.Thawing,
+	})
+
+	thawer, err := restore.NewThawer(tc, "Bulk", 7, "")
+	require.NoError(t, err)
+
+	pending, err := thawer.Request([]string{"data/aaaa/aaaa", "data/bbbb/bbbb", "data/cccc/cccc"})
+	require.NoError(t, err)
+	require.Equal(t, 2, pending)
+	require.Equal(t, 1, tc.requests["data/bbbb/bbbb"])
+	require.Equal(t, 0, tc.requests["data/cccc/cccc"])
+
+	var reports []int
+	err = thawer.Wait(context.Background(), time.Millisecond, func(pending int) {
+		reports = append(reports, pending)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 0}, reports)
+
+	// available objects are never polled
+	require.Equal(t, 0, tc.polls["data/aaaa/aaaa"])
+}
+
+func TestThawResume(t *testing.T) {
+	state_file := filepath.Join(t.TempDir(), "thaw.json")
+
+	tc := newThawClient(map[string]s3io.ArchiveState{
+		"data/aaaa/aaaa": s3io.Available,
+		"data/bbbb/bbbb": s3io.Archived,
+	})
+	keys := []string{"data/aaaa/aaaa", "data/bbbb/bbbb"}
+
+	thawer, err := restore.NewThawer(tc, "Bulk", 7, state_file)
+	require.NoError(t, err)
+	pending, err := thawer.Request(keys)
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+
+	// a second run picks up the state and doesn't request or check the objects again
+	thawer, err = restore.NewThawer(tc, "Bulk", 7, state_file)
+	require.NoError(t, err)
+	pending, err = thawer.Request(keys)
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+	require.Equal(t, 1, tc.requests["data/bbbb/bbbb"])
+
+	pending, err = thawer.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+	pending, err = thawer.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 0, pending)
+
+	thawer, err = restore.NewThawer(tc, "Bulk", 7, state_file)
+	require.NoError(t, err)
+	_, err = thawer.Request(keys)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"available": 2}, thawer.Counts())
+}
+
+func TestThawOtherRestores(t *testing.T) {
+	state_file := filepath.Join(t.TempDir(), "thaw.json")
+
+	tc := newThawClient(map[string]s3io.ArchiveState{
+		"data/aaaa/aaaa": s3io.Archived,
+		"data/bbbb/bbbb": s3io.Archived,
+	})
+
+	// an earlier restore left an object thawing in the state file
+	thawer, err := restore.NewThawer(tc, "Bulk", 7, state_file)
+	require.NoError(t, err)
+	_, err = thawer.Request([]string{"data/aaaa/aaaa"})
+	require.NoError(t, err)
+
+	// a restore of other files with the same file only counts and polls its own
+	thawer, err = restore.NewThawer(tc, "Bulk", 7, state_file)
+	require.NoError(t, err)
+	pending, err := thawer.Request([]string{"data/bbbb/bbbb"})
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+	require.Equal(t, map[string]int{"thawing": 1}, thawer.Counts())
+
+	err = thawer.Wait(context.Background(), time.Millisecond, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"available": 1}, thawer.Counts())
+	require.Equal(t, 0, tc.polls["data/aaaa/aaaa"])
+}
+
+func TestThawExpired(t *testing.T) {
+	tc := newThawClient(map[string]s3io.ArchiveState{
+		"data/bbbb/bbbb": s3io.Thawing,
+	})
+
+	thawer, err := restore.NewThawer(tc, "Bulk", 7, "")
+	require.NoError(t, err)
+	_, err = thawer.Request([]string{"data/bbbb/bbbb"})
+	require.NoError(t, err)
+
+	// the copy expired before it was seen, so it's requested again
+	tc.states["data/bbbb/bbbb"] = s3io.Archived
+	pending, err := thawer.Poll()
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+	require.Equal(t, 1, tc.requests["data/bbbb/bbbb"])
+}
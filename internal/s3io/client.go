@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"sync"
 
 	"filippo.io/age"
 	"gopkg.in/yaml.v3"
@@ -20,25 +21,59 @@ import (
 
 type Client interface {
 	Exists(key string) (bool, error)
+	ETag(key string) (string, error)
 	LatestMatching(prefix string) (string, int64, error)
+	ListMatching(prefix string) ([]string, error)
 
 	Upload(key string, source io.Reader) (int64, error)
 	UploadCompressed(key string, source io.Reader) (int64, error)
 	UploadEncrypted(key string, source io.Reader, compress bool) (int64, error)
 	UploadPassphrase(key string, source io.Reader, compress bool) (int64, error)
 
+	Put(key string, data []byte) (string, error)
+	PutIfAbsent(key string, data []byte) (string, error)
+	PutIfMatch(key string, data []byte, etag string) (string, error)
+	Read(key string) ([]byte, string, error)
+
+	Move(src, dst string) error
+	Delete(key string) error
+	DeleteIfMatch(key, etag string) error
+
 	HasIdentities() bool
+	Endpoint() string
+	RepoConfig() *RepoConfig
+
+	SetUploadLimit(limit *RateLimit)
+	SetDownloadLimit(limit *RateLimit)
+	SetStagingDir(dir string)
+	SetUploadPolicy(prefix string, policy *UploadPolicy)
+	Clone() Client
 
 	Download(key string, sink io.Writer) (int64, error)
+
+	ArchiveState(key string) (ArchiveState, error)
+	Thaw(key string, tier string, days int) (ArchiveState, error)
 }
 
 type client struct {
 	client      *s3.Client
 	bucket      *string
+	endpoint    string
 	recipients  []age.Recipient
 	identities  []age.Identity
 	passkeys    []string
 	passphrases map[string]string
+
+	upload_limiter   *Limiter
+	download_limiter *Limiter
+
+	// large downloads are staged here to fetch them in parallel
+	staging_dir string
+
+	policy_mu sync.Mutex
+	policies  map[string]*UploadPolicy
+
+	repo_config *RepoConfig
 }
 
 func NewClient(profile, bucket string, identities_file, secrets_file string) (Client, error) {
@@ -52,6 +87,22 @@ func NewClient(profile, bucket string, identities_file, secrets_file string) (Cl
 	// create the client
 	s3client := s3.NewFromConfig(cfg)
 
+	// the endpoint tells apart buckets with the same name on different services
+	endpoint := aws.ToString(cfg.BaseEndpoint)
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+
+	// check the repository is a format we can work with
+	repo_config, err := loadRepoConfig(s3client, bucket)
+	if err != nil {
+		return nil, err
+	}
+	err = repo_config.Check()
+	if err != nil {
+		return nil, err
+	}
+
 	// load the various encryption key
 	recipients, err := loadRecipients(s3client, bucket)
 	if err != nil {
@@ -70,19 +121,81 @@ func NewClient(profile, bucket string, identities_file, secrets_file string) (Cl
 	cl := client{
 		client:      s3client,
 		bucket:      aws.String(bucket),
+		endpoint:    endpoint,
 		recipients:  recipients,
 		identities:  identities,
 		passkeys:    passkeys,
 		passphrases: passphrases,
+
+		upload_limiter:   NewLimiter(nil),
+		download_limiter: NewLimiter(nil),
+
+		repo_config: repo_config,
 	}
 
 	return &cl, nil
 }
 
+// Clone returns a client sharing the connection and keys, with its own copy of the
+// upload policies and its own rate limiters, so a job can set them without
+// affecting other jobs running at the same time.
+func (cl *client) Clone() Client {
+	cl.policy_mu.Lock()
+	defer cl.policy_mu.Unlock()
+
+	policies := make(map[string]*UploadPolicy, len(cl.policies))
+	for prefix, policy := range cl.policies {
+		policies[prefix] = policy
+	}
+
+	clone := client{
+		client:      cl.client,
+		bucket:      cl.bucket,
+		endpoint:    cl.endpoint,
+		recipients:  cl.recipients,
+		identities:  cl.identities,
+		passkeys:    cl.passkeys,
+		passphrases: cl.passphrases,
+
+		upload_limiter:   NewLimiter(cl.upload_limiter.Limit()),
+		download_limiter: NewLimiter(cl.download_limiter.Limit()),
+
+		staging_dir: cl.staging_dir,
+
+		policies: policies,
+
+		repo_config: cl.repo_config,
+	}
+	return &clone
+}
+
 func (cl *client) HasIdentities() bool {
 	return len(cl.identities) > 0
 }
 
+// Endpoint returns the url of the service the bucket is on.
+func (cl *client) Endpoint() string {
+	return cl.endpoint
+}
+
+// SetUploadLimit limits the total rate of uploads, with nil being unlimited.
+func (cl *client) SetUploadLimit(limit *RateLimit) {
+	cl.upload_limiter.SetLimit(limit)
+}
+
+// SetDownloadLimit limits the total rate of downloads, with nil being unlimited.
+func (cl *client) SetDownloadLimit(limit *RateLimit) {
+	cl.download_limiter.SetLimit(limit)
+}
+
+// SetStagingDir has large downloads fetched in parallel parts to a file in the
+// directory before they're decrypted; with no directory they're streamed straight
+// through. The directory should be on the same filesystem as the destination, as
+// the staged file is as large as the object.
+func (cl *client) SetStagingDir(dir string) {
+	cl.staging_dir = dir
+}
+
 func loadRecipients(cl *s3.Client, bucket string) ([]age.Recipient, error) {
 
 	resp, err := cl.GetObject(context.Background(), &s3.GetObjectInput{
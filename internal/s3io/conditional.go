@@ -0,0 +1,122 @@
+package s3io
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// conditionalError maps the responses to a failed condition, or to a store that
+// doesn't support the condition, to their errors.
+func conditionalError(key string, err error) error {
+	var responseError *awshttp.ResponseError
+	if !errors.As(err, &responseError) {
+		return err
+	}
+
+	switch responseError.ResponseError.HTTPStatusCode() {
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return &ErrPreconditionFailed{
+			key: key,
+		}
+	case http.StatusNotImplemented:
+		return &ErrConditionNotSupported{
+			key: key,
+		}
+	case http.StatusNotFound:
+		return &ErrNoSuchObject{
+			key: key,
+		}
+	}
+	return err
+}
+
+// Put writes the data to the key as it is, returning the ETag of the new object.
+// Like the conditional writes, it's meant for small objects such as locks, and
+// isn't held back by the upload limit or given the upload policy.
+func (cl *client) Put(key string, data []byte) (string, error) {
+	poo, err := cl.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: cl.bucket,
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(poo.ETag), nil
+}
+
+// PutIfAbsent writes the data to the key only if there's no object there, returning
+// the ETag of the new object. The data is written as it is; it's meant for small
+// objects such as locks.
+func (cl *client) PutIfAbsent(key string, data []byte) (string, error) {
+	poo, err := cl.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      cl.bucket,
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		IfNoneMatch: aws.String("*"),
+	})
+	if err != nil {
+		return "", conditionalError(key, err)
+	}
+	return aws.ToString(poo.ETag), nil
+}
+
+// PutIfMatch replaces the object only if it still has the ETag, returning the ETag
+// of the new object.
+func (cl *client) PutIfMatch(key string, data []byte, etag string) (string, error) {
+	poo, err := cl.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:  cl.bucket,
+		Key:     aws.String(key),
+		Body:    bytes.NewReader(data),
+		IfMatch: aws.String(etag),
+	})
+	if err != nil {
+		return "", conditionalError(key, err)
+	}
+	return aws.ToString(poo.ETag), nil
+}
+
+// DeleteIfMatch deletes the object only if it still has the ETag.
+func (cl *client) DeleteIfMatch(key, etag string) error {
+	_, err := cl.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket:  cl.bucket,
+		Key:     aws.String(key),
+		IfMatch: aws.String(etag),
+	})
+	if err != nil {
+		return conditionalError(key, err)
+	}
+	return nil
+}
+
+// Read returns the content of a small object as it's stored, with its ETag.
+func (cl *client) Read(key string) ([]byte, string, error) {
+	resp, err := cl.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: cl.bucket,
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nosuchkey *types.NoSuchKey
+		if errors.As(err, &nosuchkey) {
+			return nil, "", &ErrNoSuchObject{
+				key: key,
+			}
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, aws.ToString(resp.ETag), nil
+}
@@ -5,11 +5,13 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
 	"strings"
 
 	"filippo.io/age"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
@@ -20,67 +22,81 @@ var downloadable = map[string]bool{
 	string(types.StorageClassReducedRedundancy): true,
 	string(types.StorageClassStandardIa):        true,
 	string(types.StorageClassOnezoneIa):         true,
+	string(types.StorageClassGlacierIr):         true,
 }
 
-func (cl *client) checkDownloadable(key string) error {
-	hoo, err := cl.client.HeadObject(context.Background(), &s3.HeadObjectInput{
-		Bucket: cl.bucket,
-		Key:    aws.String(key),
-	})
+func (cl *client) checkDownloadable(key string) (*s3.HeadObjectOutput, error) {
+	hoo, err := cl.head(key)
 	if err != nil {
-		var nosuchkey *types.NoSuchKey
-		if errors.As(err, &nosuchkey) {
-			return &ErrNoSuchObject{
-				key: key,
-			}
-		}
-		return err
+		return nil, err
 	}
 
-	sclass := string(hoo.StorageClass)
-	if downloadable[sclass] {
-		return nil
+	// archived objects can be downloaded once they've been thawed
+	state, err := archiveState(key, hoo)
+	if err != nil {
+		return nil, err
 	}
-
-	return &ErrNotDownloadable{
-		key:          key,
-		storageClass: sclass,
+	if state != Available {
+		return nil, &ErrNotDownloadable{
+			key:          key,
+			storageClass: string(hoo.StorageClass),
+			state:        state,
+		}
 	}
+
+	return hoo, nil
 }
 
 func (cl *client) Download(key string, sink io.Writer) (int64, error) {
 
 	// verify we can download the object
-	err := cl.checkDownloadable(key)
+	hoo, err := cl.checkDownloadable(key)
 	if err != nil {
 		return 0, err
 	}
 
-	// use the simple GetObject method as we won't have a io.WriterAt interface
-	//   to use the manager/paraller downloader
-	resp, err := cl.client.GetObject(context.Background(), &s3.GetObjectInput{
-		Bucket: cl.bucket,
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		var nosuchkey *types.NoSuchKey
-		if errors.As(err, &nosuchkey) {
-			return 0, &ErrNoSuchObject{
-				key: key,
+	// with a staging directory, large objects are downloaded in parallel to a
+	//   temporary file there, as the manager/parallel downloader needs an
+	//   io.WriterAt; the rest use the simple GetObject method and stream
+	//   straight through
+	var reader io.Reader
+	meta := hoo.Metadata
+
+	if cl.staging_dir != "" && aws.ToInt64(hoo.ContentLength) >= parallelDownloadSize {
+		tmpfile, err := cl.downloadParallel(key)
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		reader = tmpfile
+
+	} else {
+		resp, err := cl.client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: cl.bucket,
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			var nosuchkey *types.NoSuchKey
+			if errors.As(err, &nosuchkey) {
+				return 0, &ErrNoSuchObject{
+					key: key,
+				}
 			}
+			return 0, err
 		}
-		return 0, err
-	}
-	defer resp.Body.Close()
+		defer resp.Body.Close()
 
-	var reader io.Reader = resp.Body
+		reader = NewLimitedReader(resp.Body, cl.download_limiter)
+		meta = resp.Metadata
+	}
 
 	// check the meta data to see if decompressing/decryption is needed
 	compressed := false
 	encrypted := false
 	passkey := ""
 
-	meta := resp.Metadata
 	for k, v := range meta {
 		if "s3bu-compress" == strings.ToLower(k) {
 			compressed = true
@@ -146,3 +162,54 @@ func (cl *client) Download(key string, sink io.Writer) (int64, error) {
 
 	return nbytes, nil
 }
+
+// objects at least this size are downloaded in parallel parts
+const parallelDownloadSize int64 = 64 * 1024 * 1024
+
+const downloadPartSize int64 = 16 * 1024 * 1024
+
+// downloadParallel downloads the raw object to a temporary file in the staging
+// directory using the manager's parallel downloader. The file is returned rewound, ready to read.
+func (cl *client) downloadParallel(key string) (*os.File, error) {
+	tmpfile, err := os.CreateTemp(cl.staging_dir, ".s3bu-download-*")
+	if err != nil {
+		return nil, err
+	}
+
+	downloader := manager.NewDownloader(cl.client, func(d *manager.Downloader) {
+		d.PartSize = downloadPartSize
+	})
+
+	_, err = downloader.Download(context.Background(), &limitedWriterAt{tmpfile, cl.download_limiter}, &s3.GetObjectInput{
+		Bucket: cl.bucket,
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		_, err = tmpfile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmpfile.Close()
+		os.Remove(tmpfile.Name())
+
+		var nosuchkey *types.NoSuchKey
+		if errors.As(err, &nosuchkey) {
+			return nil, &ErrNoSuchObject{
+				key: key,
+			}
+		}
+		return nil, err
+	}
+
+	return tmpfile, nil
+}
+
+// limitedWriterAt applies the download limit to the parallel downloader.
+type limitedWriterAt struct {
+	out     io.WriterAt
+	limiter *Limiter
+}
+
+func (lw *limitedWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	lw.limiter.Wait(len(p))
+	return lw.out.WriteAt(p, off)
+}
@@ -18,6 +18,30 @@ func (e *ErrNoRecipientsFile) Error() string {
 	return "recipients file not found: expected in bucket at 'repo/recipients.txt'"
 }
 
+type ErrRecipientsMismatch struct {
+	bucket string
+}
+
+func (e *ErrRecipientsMismatch) Error() string {
+	return fmt.Sprintf("bucket %s already has a recipients file for different identities", e.bucket)
+}
+
+type ErrRepoVersion struct {
+	version int
+}
+
+func (e *ErrRepoVersion) Error() string {
+	return fmt.Sprintf("repository format version %d is newer than this version of the tools supports (%d): upgrade the tools", e.version, RepoVersion)
+}
+
+type ErrRepoFeature struct {
+	feature string
+}
+
+func (e *ErrRepoFeature) Error() string {
+	return fmt.Sprintf("repository uses a feature this version of the tools doesn't support: %s", e.feature)
+}
+
 type ErrNoSecretsFile struct{}
 
 func (e *ErrNoSecretsFile) Error() string {
@@ -54,6 +78,22 @@ func (e *ErrNoSuchObject) Error() string {
 	return fmt.Sprintf("no such object in bucket: %s", e.key)
 }
 
+type ErrPreconditionFailed struct {
+	key string
+}
+
+func (e *ErrPreconditionFailed) Error() string {
+	return fmt.Sprintf("object changed by another client: %s", e.key)
+}
+
+type ErrConditionNotSupported struct {
+	key string
+}
+
+func (e *ErrConditionNotSupported) Error() string {
+	return fmt.Sprintf("conditional requests not supported by the bucket: %s", e.key)
+}
+
 type ErrNoMatch struct {
 	msg string
 }
@@ -65,8 +105,25 @@ func (e *ErrNoMatch) Error() string {
 type ErrNotDownloadable struct {
 	key          string
 	storageClass string
+	state        ArchiveState
 }
 
 func (e *ErrNotDownloadable) Error() string {
+	switch e.state {
+	case Archived:
+		return fmt.Sprintf("object is archived in %s: it must be thawed before download", e.storageClass)
+	case Thawing:
+		return fmt.Sprintf("object is archived in %s: thaw in progress", e.storageClass)
+	}
 	return fmt.Sprintf("object is not downloadable: storage class is %s", e.storageClass)
 }
+
+type ErrHashMismatch struct {
+	key      string
+	expected string
+	actual   string
+}
+
+func (e *ErrHashMismatch) Error() string {
+	return fmt.Sprintf("content hash mismatch for %s: got %s", e.key, e.actual)
+}
@@ -27,3 +27,12 @@ func (cl *client) Exists(key string) (bool, error) {
 
 	return false, err
 }
+
+// ETag returns the entity tag of the object, which changes whenever its content does.
+func (cl *client) ETag(key string) (string, error) {
+	hoo, err := cl.head(key)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(hoo.ETag), nil
+}
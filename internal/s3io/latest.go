@@ -38,3 +38,30 @@ func (cl *client) LatestMatching(prefix string) (string, int64, error) {
 		msg: fmt.Sprintf("No objects found with prefix: %s", prefix),
 	}
 }
+
+// ListMatching returns the keys of all the objects with the prefix, in order.
+func (cl *client) ListMatching(prefix string) ([]string, error) {
+
+	loi := s3.ListObjectsV2Input{
+		Bucket: cl.bucket,
+		Prefix: aws.String(prefix),
+	}
+
+	var keys []string
+	for {
+		resp, err := cl.client.ListObjectsV2(context.Background(), &loi)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range resp.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+
+		if aws.ToBool(resp.IsTruncated) == false {
+			break
+		}
+		loi.ContinuationToken = resp.NextContinuationToken
+	}
+
+	return keys, nil
+}
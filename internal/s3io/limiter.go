@@ -0,0 +1,219 @@
+package s3io
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+type ErrBadRateLimit struct {
+	msg string
+}
+
+func (e *ErrBadRateLimit) Error() string {
+	return e.msg
+}
+
+// RateLimit is a transfer rate in bytes per second that can vary with the time
+// of day. A rate of zero is unlimited.
+type RateLimit struct {
+	spec    string
+	rate    int64
+	windows []rateWindow
+}
+
+// rateWindow is a rate that applies from start up to end, in minutes from
+// midnight. The window wraps around midnight if end is before start.
+type rateWindow struct {
+	start int
+	end   int
+	rate  int64
+}
+
+// ParseRateLimit parses a comma separated list of rates such as '2MB' or '512KiB/s',
+// each optionally followed by a time of day window, e.g. '2MB@08:00-18:00'. The
+// first matching window applies; outside the windows the rate without a window
+// applies, or it's unlimited. An empty spec, '0' or 'unlimited' is unlimited.
+func ParseRateLimit(spec string) (*RateLimit, error) {
+	rl := RateLimit{
+		spec: spec,
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		rate, window, found := strings.Cut(part, "@")
+		bps, err := parseRate(rate)
+		if err != nil {
+			return nil, &ErrBadRateLimit{
+				msg: fmt.Sprintf("bad rate in limit %s: %s", spec, rate),
+			}
+		}
+
+		if !found {
+			rl.rate = bps
+			continue
+		}
+
+		from, to, ok := strings.Cut(window, "-")
+		start, err1 := parseTimeOfDay(from)
+		end, err2 := parseTimeOfDay(to)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, &ErrBadRateLimit{
+				msg: fmt.Sprintf("bad time window in limit %s: %s", spec, window),
+			}
+		}
+
+		rl.windows = append(rl.windows, rateWindow{start: start, end: end, rate: bps})
+	}
+
+	return &rl, nil
+}
+
+func parseRate(rate string) (int64, error) {
+	rate = strings.TrimSuffix(strings.TrimSpace(rate), "/s")
+	if rate == "unlimited" {
+		return 0, nil
+	}
+	bps, err := humanize.ParseBytes(rate)
+	if err != nil {
+		return 0, err
+	}
+	return int64(bps), nil
+}
+
+func parseTimeOfDay(tod string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(tod))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (rl *RateLimit) String() string {
+	return rl.spec
+}
+
+// RateAt returns the rate in bytes per second at the time, or zero if unlimited.
+func (rl *RateLimit) RateAt(t time.Time) int64 {
+	if rl == nil {
+		return 0
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range rl.windows {
+		inside := minute >= w.start && minute < w.end
+		if w.end < w.start {
+			inside = minute >= w.start || minute < w.end
+		}
+		if inside {
+			return w.rate
+		}
+	}
+
+	return rl.rate
+}
+
+// Limiter is a token bucket shared by all the transfers in one direction, so
+// the limit applies to the total rate. It allows a burst of up to a second of
+// data at the current rate.
+type Limiter struct {
+	mu     sync.Mutex
+	limit  *RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(limit *RateLimit) *Limiter {
+	return &Limiter{
+		limit: limit,
+	}
+}
+
+// SetLimit changes the limit, with nil being unlimited.
+func (l *Limiter) SetLimit(limit *RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+}
+
+// Limit returns the current limit, with nil being unlimited.
+func (l *Limiter) Limit() *RateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limit
+}
+
+// Wait blocks until the bytes can be transferred within the limit.
+func (l *Limiter) Wait(nbytes int) {
+	l.mu.Lock()
+
+	now := time.Now()
+	rate := float64(l.limit.RateAt(now))
+	if rate == 0 {
+		l.tokens = 0
+		l.last = now
+		l.mu.Unlock()
+		return
+	}
+
+	// refill the bucket, allowing a second's burst; it starts full
+	if l.last.IsZero() {
+		l.tokens = rate
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+	}
+	l.tokens = min(l.tokens, rate)
+	l.last = now
+
+	// take the tokens, going into debt if there aren't enough
+	l.tokens -= float64(nbytes)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// limitedReader reads in small chunks so the throughput is smooth.
+const limitedChunkSize = 32 * 1024
+
+type limitedReader struct {
+	in      io.Reader
+	limiter *Limiter
+}
+
+// NewLimitedReader returns a reader that reads from in no faster than the
+// limiter allows.
+func NewLimitedReader(in io.Reader, limiter *Limiter) io.Reader {
+	return &limitedReader{
+		in:      in,
+		limiter: limiter,
+	}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitedChunkSize {
+		p = p[:limitedChunkSize]
+	}
+
+	size, err := lr.in.Read(p)
+	if size > 0 {
+		lr.limiter.Wait(size)
+	}
+
+	return size, err
+}
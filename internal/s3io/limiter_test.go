@@ -0,0 +1,75 @@
+package s3io_test
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 3, 4, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseRateLimit(t *testing.T) {
+	rl, err := s3io.ParseRateLimit("2MB@08:00-18:00")
+	require.NoError(t, err)
+	require.Equal(t, int64(2000000), rl.RateAt(at(8, 0)))
+	require.Equal(t, int64(2000000), rl.RateAt(at(17, 59)))
+	require.Equal(t, int64(0), rl.RateAt(at(18, 0)))
+	require.Equal(t, int64(0), rl.RateAt(at(7, 59)))
+
+	rl, err = s3io.ParseRateLimit("1MiB/s@22:00-06:00, 512KiB")
+	require.NoError(t, err)
+	require.Equal(t, int64(1<<20), rl.RateAt(at(23, 0)))
+	require.Equal(t, int64(1<<20), rl.RateAt(at(5, 0)))
+	require.Equal(t, int64(512<<10), rl.RateAt(at(12, 0)))
+
+	rl, err = s3io.ParseRateLimit("unlimited")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), rl.RateAt(at(12, 0)))
+
+	var nolimit *s3io.RateLimit
+	require.Equal(t, int64(0), nolimit.RateAt(at(12, 0)))
+}
+
+func TestParseBadRateLimit(t *testing.T) {
+	for _, spec := range []string{"fast", "2MB@8-18", "2MB@08:00", "2MB@25:00-26:00"} {
+		_, err := s3io.ParseRateLimit(spec)
+
+		var badlimit *s3io.ErrBadRateLimit
+		require.ErrorAs(t, err, &badlimit, spec)
+	}
+}
+
+func TestLimitedReaderIsLimited(t *testing.T) {
+	rl, err := s3io.ParseRateLimit("200KB")
+	require.NoError(t, err)
+
+	// the first second is a burst, so this should take about a second
+	data := make([]byte, 400000)
+	reader := s3io.NewLimitedReader(bytes.NewReader(data), s3io.NewLimiter(rl))
+
+	start := time.Now()
+	nbytes, err := io.Copy(io.Discard, reader)
+	elapsed := time.Since(start)
+
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), nbytes)
+	require.Greater(t, elapsed, 800*time.Millisecond)
+	require.Less(t, elapsed, 2*time.Second)
+}
+
+func TestLimiterLimit(t *testing.T) {
+	l := s3io.NewLimiter(nil)
+	require.Nil(t, l.Limit())
+
+	limit, err := s3io.ParseRateLimit("2MB")
+	require.NoError(t, err)
+	l.SetLimit(limit)
+	require.Equal(t, limit, l.Limit())
+}
@@ -0,0 +1,127 @@
+package s3io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// objects larger than this need a multipart copy
+const maxCopySize int64 = 5 * 1024 * 1024 * 1024
+
+const copyPartSize int64 = 512 * 1024 * 1024
+
+// Move copies the object to the new key, keeping the metadata, then deletes the original.
+// The copy gets the storage class and tags of the upload policy for the new key.
+func (cl *client) Move(src, dst string) error {
+
+	hoo, err := cl.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: cl.bucket,
+		Key:    aws.String(src),
+	})
+	if err != nil {
+		var nosuchkey *types.NoSuchKey
+		if errors.As(err, &nosuchkey) {
+			return &ErrNoSuchObject{
+				key: src,
+			}
+		}
+		return err
+	}
+
+	sclass, tagging := cl.policyFor(dst)
+
+	size := aws.ToInt64(hoo.ContentLength)
+	if size <= maxCopySize {
+		coi := s3.CopyObjectInput{
+			Bucket:       cl.bucket,
+			Key:          aws.String(dst),
+			CopySource:   cl.copySource(src),
+			StorageClass: sclass,
+		}
+		if tagging != nil {
+			coi.Tagging = tagging
+			coi.TaggingDirective = types.TaggingDirectiveReplace
+		}
+		_, err = cl.client.CopyObject(context.Background(), &coi)
+	} else {
+		err = cl.multipartCopy(src, dst, size, hoo.Metadata, sclass, tagging)
+	}
+	if err != nil {
+		return err
+	}
+
+	return cl.Delete(src)
+}
+
+func (cl *client) Delete(key string) error {
+
+	_, err := cl.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: cl.bucket,
+		Key:    aws.String(key),
+	})
+
+	return err
+}
+
+func (cl *client) copySource(key string) *string {
+	return aws.String(fmt.Sprintf("%s/%s", aws.ToString(cl.bucket), url.PathEscape(key)))
+}
+
+func (cl *client) multipartCopy(src, dst string, size int64, mdata map[string]string, sclass types.StorageClass, tagging *string) error {
+	ctx := context.Background()
+
+	cmu, err := cl.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:       cl.bucket,
+		Key:          aws.String(dst),
+		Metadata:     mdata,
+		StorageClass: sclass,
+		Tagging:      tagging,
+	})
+	if err != nil {
+		return err
+	}
+
+	var parts []types.CompletedPart
+	for offset, number := int64(0), int32(1); offset < size; offset, number = offset+copyPartSize, number+1 {
+		last := min(offset+copyPartSize, size) - 1
+
+		resp, err := cl.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          cl.bucket,
+			Key:             aws.String(dst),
+			UploadId:        cmu.UploadId,
+			PartNumber:      aws.Int32(number),
+			CopySource:      cl.copySource(src),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, last)),
+		})
+		if err != nil {
+			cl.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   cl.bucket,
+				Key:      aws.String(dst),
+				UploadId: cmu.UploadId,
+			})
+			return err
+		}
+
+		parts = append(parts, types.CompletedPart{
+			ETag:       resp.CopyPartResult.ETag,
+			PartNumber: aws.Int32(number),
+		})
+	}
+
+	_, err = cl.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   cl.bucket,
+		Key:      aws.String(dst),
+		UploadId: cmu.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+
+	return err
+}
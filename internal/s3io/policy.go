@@ -0,0 +1,108 @@
+package s3io
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// the limits S3 puts on object tags
+const (
+	maxTags        = 10
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+)
+
+// UploadPolicy is the storage class and tags for objects uploaded under a key
+// prefix. An empty storage class uses the bucket default.
+type UploadPolicy struct {
+	StorageClass string
+	Tags         map[string]string
+}
+
+// ParseStorageClass checks the storage class name, returning it in the form S3
+// expects.
+func ParseStorageClass(name string) (string, error) {
+	for _, sclass := range types.StorageClass("").Values() {
+		if strings.EqualFold(name, string(sclass)) {
+			return string(sclass), nil
+		}
+	}
+	return "", fmt.Errorf("unknown storage class: %s", name)
+}
+
+// Validate checks the storage class and tags against what S3 accepts, putting
+// the storage class name into the form S3 expects.
+func (p *UploadPolicy) Validate() error {
+	if p.StorageClass != "" {
+		sclass, err := ParseStorageClass(p.StorageClass)
+		if err != nil {
+			return err
+		}
+		p.StorageClass = sclass
+	}
+
+	if len(p.Tags) > maxTags {
+		return fmt.Errorf("too many tags: %d, the most allowed is %d", len(p.Tags), maxTags)
+	}
+	for k, v := range p.Tags {
+		if k == "" || len(k) > maxTagKeyLen {
+			return fmt.Errorf("tag keys must be 1 to %d characters: '%s'", maxTagKeyLen, k)
+		}
+		if len(v) > maxTagValueLen {
+			return fmt.Errorf("tag values must be no more than %d characters: %s", maxTagValueLen, k)
+		}
+	}
+
+	return nil
+}
+
+// SetUploadPolicy sets the policy for objects uploaded or moved to keys under the
+// prefix, or removes it if the policy is nil. The policy with the longest matching
+// prefix applies.
+func (cl *client) SetUploadPolicy(prefix string, policy *UploadPolicy) {
+	cl.policy_mu.Lock()
+	defer cl.policy_mu.Unlock()
+
+	if policy == nil {
+		delete(cl.policies, prefix)
+		return
+	}
+	if cl.policies == nil {
+		cl.policies = make(map[string]*UploadPolicy)
+	}
+	cl.policies[prefix] = policy
+}
+
+// policyFor returns the storage class and the tags, encoded for a request header,
+// for the key. Both are empty if there's no policy.
+func (cl *client) policyFor(key string) (types.StorageClass, *string) {
+	cl.policy_mu.Lock()
+	defer cl.policy_mu.Unlock()
+
+	var policy *UploadPolicy
+	matched := -1
+	for prefix, p := range cl.policies {
+		if strings.HasPrefix(key, prefix) && len(prefix) > matched {
+			policy = p
+			matched = len(prefix)
+		}
+	}
+	if policy == nil {
+		return "", nil
+	}
+
+	var tagging *string
+	if len(policy.Tags) > 0 {
+		values := url.Values{}
+		for k, v := range policy.Tags {
+			values.Set(k, v)
+		}
+		tagging = aws.String(values.Encode())
+	}
+
+	return types.StorageClass(policy.StorageClass), tagging
+}
@@ -0,0 +1,37 @@
+package s3io_test
+
+import (
+	"fmt"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+func TestParseStorageClass(t *testing.T) {
+	sclass, err := s3io.ParseStorageClass("standard_ia")
+	require.NoError(t, err)
+	require.Equal(t, "STANDARD_IA", sclass)
+
+	_, err = s3io.ParseStorageClass("CHEAP")
+	require.Error(t, err)
+}
+
+func TestUploadPolicyValidate(t *testing.T) {
+	p := s3io.UploadPolicy{
+		StorageClass: "glacier_ir",
+		Tags:         map[string]string{"job": "home"},
+	}
+	require.NoError(t, p.Validate())
+	require.Equal(t, "GLACIER_IR", p.StorageClass)
+
+	p = s3io.UploadPolicy{Tags: map[string]string{"": "empty"}}
+	require.Error(t, p.Validate())
+
+	p = s3io.UploadPolicy{Tags: map[string]string{}}
+	for i := 0; i < 11; i++ {
+		p.Tags[fmt.Sprintf("tag%d", i)] = "value"
+	}
+	require.Error(t, p.Validate())
+}
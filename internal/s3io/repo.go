@@ -0,0 +1,218 @@
+package s3io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// RepoConfigKey is the key of the object that marks the bucket as a repository and
+// records its format.
+const RepoConfigKey = "repo/config.yml"
+
+// RepoVersion is the format of the repository written by this version of the tools.
+// Repositories without a marker were made before there was one, and are version 0.
+const RepoVersion = 1
+
+// Features are the encodings and conventions a repository uses, named with the
+// versions in the object metadata. A tool can't work with a repository using a
+// feature it doesn't know.
+var Features = []string{
+	"compress-gzip-001",
+	"encrypt-age-001",
+	"scrypt-age-001",
+	"locks",
+}
+
+// RepoConfig is the content of the repository marker.
+type RepoConfig struct {
+	Version  int       `yaml:"version"`
+	Created  time.Time `yaml:"created"`
+	Features []string  `yaml:"features"`
+}
+
+// NewRepoConfig returns the marker for a new repository in the current format.
+func NewRepoConfig() *RepoConfig {
+	return &RepoConfig{
+		Version:  RepoVersion,
+		Created:  time.Now().UTC().Truncate(time.Second),
+		Features: append([]string(nil), Features...),
+	}
+}
+
+func ParseRepoConfig(data []byte) (*RepoConfig, error) {
+	var rc RepoConfig
+	err := yaml.Unmarshal(data, &rc)
+	if err != nil {
+		return nil, fmt.Errorf("bad repository config: %w", err)
+	}
+	if rc.Version < 1 {
+		return nil, fmt.Errorf("bad repository config: no version")
+	}
+	return &rc, nil
+}
+
+func (rc *RepoConfig) Marshal() ([]byte, error) {
+	return yaml.Marshal(rc)
+}
+
+// Check returns an error if the repository is in a newer format, or uses features,
+// that this version of the tools doesn't know.
+func (rc *RepoConfig) Check() error {
+	if rc.Version > RepoVersion {
+		return &ErrRepoVersion{
+			version: rc.Version,
+		}
+	}
+
+	for _, feature := range rc.Features {
+		known := false
+		for _, f := range Features {
+			known = known || f == feature
+		}
+		if !known {
+			return &ErrRepoFeature{
+				feature: feature,
+			}
+		}
+	}
+	return nil
+}
+
+// loadRepoConfig reads the repository marker, with a missing marker being version 0.
+func loadRepoConfig(cl *s3.Client, bucket string) (*RepoConfig, error) {
+
+	resp, err := cl.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(RepoConfigKey),
+	})
+	if err != nil {
+		var nosuchkey *types.NoSuchKey
+		if errors.As(err, &nosuchkey) {
+			return &RepoConfig{}, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", RepoConfigKey, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRepoConfig(data)
+}
+
+// RepoConfig returns the repository marker read when the client was created.
+func (cl *client) RepoConfig() *RepoConfig {
+	return cl.repo_config
+}
+
+// InitRepo prepares the bucket to be used as a repository by uploading the
+// recipients file, creating the bucket first if create is set. A bucket that already
+// has the same recipients is left as it is; one with different recipients is an
+// error, as the existing backups couldn't be restored with the new identities.
+// It reports whether the recipients were uploaded.
+func InitRepo(profile, bucket, recipients string, create bool) (bool, error) {
+
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithSharedConfigProfile(profile))
+	if err != nil {
+		return false, err
+	}
+	s3client := s3.NewFromConfig(cfg)
+
+	// check the bucket is there, creating it if asked to
+	_, err = s3client.HeadBucket(context.Background(), &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		var notfound *types.NotFound
+		if !errors.As(err, &notfound) || create == false {
+			return false, fmt.Errorf("failed to access bucket %s: %w", bucket, err)
+		}
+
+		cbi := s3.CreateBucketInput{
+			Bucket: aws.String(bucket),
+		}
+		if cfg.Region != "" && cfg.Region != "us-east-1" {
+			cbi.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+				LocationConstraint: types.BucketLocationConstraint(cfg.Region),
+			}
+		}
+		_, err = s3client.CreateBucket(context.Background(), &cbi)
+		if err != nil {
+			return false, fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+		}
+	}
+
+	// compare with any recipients already there
+	resp, err := s3client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("repo/recipients.txt"),
+	})
+	if err == nil {
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return false, err
+		}
+		if SameRecipients(string(data), recipients) {
+			return false, nil
+		}
+		return false, &ErrRecipientsMismatch{
+			bucket: bucket,
+		}
+	}
+	var nosuchkey *types.NoSuchKey
+	if !errors.As(err, &nosuchkey) {
+		return false, err
+	}
+
+	_, err = s3client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("repo/recipients.txt"),
+		Body:   strings.NewReader(recipients),
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SameRecipients reports whether the two recipients files have the same recipients,
+// ignoring comments, blank lines and the order.
+func SameRecipients(a, b string) bool {
+	parse := func(s string) []string {
+		var lines []string
+		for _, line := range strings.Split(s, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		sort.Strings(lines)
+		return lines
+	}
+
+	la, lb := parse(a), parse(b)
+	if len(la) != len(lb) {
+		return false
+	}
+	for i := range la {
+		if la[i] != lb[i] {
+			return false
+		}
+	}
+	return true
+}
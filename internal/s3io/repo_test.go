@@ -0,0 +1,53 @@
+package s3io_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+func TestRepoConfig(t *testing.T) {
+	rc := s3io.NewRepoConfig()
+	require.Equal(t, s3io.RepoVersion, rc.Version)
+
+	data, err := rc.Marshal()
+	require.NoError(t, err)
+
+	parsed, err := s3io.ParseRepoConfig(data)
+	require.NoError(t, err)
+	require.Equal(t, rc.Version, parsed.Version)
+	require.True(t, rc.Created.Equal(parsed.Created))
+
+	_, err = s3io.ParseRepoConfig([]byte("created: 2024-01-01T00:00:00Z\n"))
+	require.Error(t, err)
+
+	_, err = s3io.ParseRepoConfig([]byte("version: [1"))
+	require.Error(t, err)
+}
+
+func TestSameRecipients(t *testing.T) {
+	a := "# created by s3init\nage1aaa\nage1bbb\n"
+	b := "age1bbb\n\nage1aaa"
+
+	require.True(t, s3io.SameRecipients(a, b))
+	require.False(t, s3io.SameRecipients(a, "age1aaa\n"))
+	require.False(t, s3io.SameRecipients(a, "age1aaa\nage1ccc\n"))
+}
+
+func TestRepoConfigCheck(t *testing.T) {
+	require.NoError(t, s3io.NewRepoConfig().Check())
+
+	// repositories from before the marker are version 0
+	require.NoError(t, (&s3io.RepoConfig{}).Check())
+
+	rc := s3io.NewRepoConfig()
+	rc.Version = s3io.RepoVersion + 1
+	var version *s3io.ErrRepoVersion
+	require.ErrorAs(t, rc.Check(), &version)
+
+	rc = s3io.NewRepoConfig()
+	rc.Features = append(rc.Features, "compress-zstd-001")
+	var feature *s3io.ErrRepoFeature
+	require.ErrorAs(t, rc.Check(), &feature)
+}
@@ -0,0 +1,157 @@
+package s3io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ArchiveState is whether an object can be downloaded, or has to be thawed from
+// an archive storage class first.
+type ArchiveState int
+
+const (
+	Available ArchiveState = iota
+	Archived
+	Thawing
+)
+
+func (as ArchiveState) String() string {
+	switch as {
+	case Available:
+		return "available"
+	case Archived:
+		return "archived"
+	case Thawing:
+		return "thawing"
+	}
+	return "unknown"
+}
+
+// the storage classes that need a restore request before they can be downloaded
+var archived = map[string]bool{
+	string(types.StorageClassGlacier):     true,
+	string(types.StorageClassDeepArchive): true,
+}
+
+// IsArchiveClass reports if objects in the storage class have to be thawed before
+// they can be downloaded.
+func IsArchiveClass(sclass string) bool {
+	return archived[strings.ToUpper(sclass)]
+}
+
+// ParseThawTier checks the retrieval tier name, returning it in the form S3 expects.
+func ParseThawTier(name string) (string, error) {
+	for _, tier := range types.Tier("").Values() {
+		if strings.EqualFold(name, string(tier)) {
+			return string(tier), nil
+		}
+	}
+	return "", fmt.Errorf("unknown thaw tier: %s", name)
+}
+
+// archiveState works out the state from the head object response. Objects in the
+// archive storage classes, or the archive tiers of intelligent tiering, have a
+// Restore header once a restore is requested; it reads 'ongoing-request="false"'
+// once the temporary copy is available.
+func archiveState(key string, hoo *s3.HeadObjectOutput) (ArchiveState, error) {
+	sclass := string(hoo.StorageClass)
+
+	if hoo.StorageClass == types.StorageClassIntelligentTiering && hoo.ArchiveStatus == "" {
+		return Available, nil
+	}
+	if hoo.StorageClass != types.StorageClassIntelligentTiering && !archived[sclass] {
+		if downloadable[sclass] {
+			return Available, nil
+		}
+		return Available, &ErrNotDownloadable{
+			key:          key,
+			storageClass: sclass,
+		}
+	}
+
+	restore := aws.ToString(hoo.Restore)
+	switch {
+	case restore == "":
+		return Archived, nil
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return Thawing, nil
+	case strings.Contains(restore, `ongoing-request="false"`):
+		return Available, nil
+	}
+	return Archived, fmt.Errorf("unexpected restore status for %s: %s", key, restore)
+}
+
+func (cl *client) head(key string) (*s3.HeadObjectOutput, error) {
+	hoo, err := cl.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: cl.bucket,
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nosuchkey *types.NoSuchKey
+		if errors.As(err, &nosuchkey) {
+			return nil, &ErrNoSuchObject{
+				key: key,
+			}
+		}
+		return nil, err
+	}
+	return hoo, nil
+}
+
+// ArchiveState returns whether the object can be downloaded now.
+func (cl *client) ArchiveState(key string) (ArchiveState, error) {
+	hoo, err := cl.head(key)
+	if err != nil {
+		return Available, err
+	}
+	return archiveState(key, hoo)
+}
+
+// Thaw requests a restore of an archived object using the retrieval tier, keeping
+// the temporary copy for the number of days. Objects that are already available
+// or thawing are left alone. It returns the state of the object after the request.
+func (cl *client) Thaw(key string, tier string, days int) (ArchiveState, error) {
+	hoo, err := cl.head(key)
+	if err != nil {
+		return Available, err
+	}
+	state, err := archiveState(key, hoo)
+	if err != nil || state != Archived {
+		return state, err
+	}
+
+	// the intelligent tiering archive tiers restore back into the frequent
+	//   access tier, so there's no expiry for the copy
+	req := types.RestoreRequest{
+		GlacierJobParameters: &types.GlacierJobParameters{
+			Tier: types.Tier(tier),
+		},
+	}
+	if hoo.StorageClass != types.StorageClassIntelligentTiering {
+		req.Days = aws.Int32(int32(days))
+	}
+
+	_, err = cl.client.RestoreObject(context.Background(), &s3.RestoreObjectInput{
+		Bucket:         cl.bucket,
+		Key:            aws.String(key),
+		RestoreRequest: &req,
+	})
+	if err != nil {
+		// someone else got in first
+		var responseError *awshttp.ResponseError
+		if errors.As(err, &responseError) && responseError.ResponseError.HTTPStatusCode() == http.StatusConflict {
+			return Thawing, nil
+		}
+		return Archived, err
+	}
+
+	return Thawing, nil
+}
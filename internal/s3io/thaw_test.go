@@ -0,0 +1,27 @@
+package s3io_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+func TestParseThawTier(t *testing.T) {
+	tier, err := s3io.ParseThawTier("bulk")
+	require.NoError(t, err)
+	require.Equal(t, "Bulk", tier)
+
+	tier, err = s3io.ParseThawTier("Expedited")
+	require.NoError(t, err)
+	require.Equal(t, "Expedited", tier)
+
+	_, err = s3io.ParseThawTier("fast")
+	require.Error(t, err)
+}
+
+func TestArchiveStateString(t *testing.T) {
+	require.Equal(t, "available", s3io.Available.String())
+	require.Equal(t, "archived", s3io.Archived.String())
+	require.Equal(t, "thawing", s3io.Thawing.String())
+}
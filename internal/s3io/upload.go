@@ -151,8 +151,8 @@ func (cl *client) upload(key string, source io.Reader, compress, encrypt, scrypt
 	}
 
 	// count how many bytes actually get uploaded after compression
-	//   and encryption
-	counter := NewReadCounter(source)
+	//   and encryption, at no more than the upload limit
+	counter := NewReadCounter(NewLimitedReader(source, cl.upload_limiter))
 	defer counter.Close()
 
 	// can't use the simple PutObject method because don't know the ContentLength
@@ -162,11 +162,15 @@ func (cl *client) upload(key string, source io.Reader, compress, encrypt, scrypt
 
 	uploader := manager.NewUploader(cl.client)
 
+	sclass, tagging := cl.policyFor(key)
+
 	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:   cl.bucket,
-		Key:      aws.String(key),
-		Body:     counter,
-		Metadata: mdata,
+		Bucket:       cl.bucket,
+		Key:          aws.String(key),
+		Body:         counter,
+		Metadata:     mdata,
+		StorageClass: sclass,
+		Tagging:      tagging,
 	})
 
 	return counter.TotalBytes(), err
@@ -0,0 +1,61 @@
+package s3io
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+var dataKeyRe = regexp.MustCompile(`^data/[0-9a-f]{4}/([0-9a-f]{64})$`)
+
+// HashFromKey returns the content hash for a data key, or an empty string if the
+// key isn't a data key.
+func HashFromKey(key string) string {
+	matches := dataKeyRe.FindStringSubmatch(key)
+	if matches == nil {
+		return ""
+	}
+	return matches[1]
+}
+
+// DownloadVerified downloads the object, computing the SHA-256 of the content as
+// it's written to the sink. If it doesn't match the hash, an ErrHashMismatch is
+// returned and the data written to the sink must be discarded.
+func DownloadVerified(client Client, key, hash string, sink io.Writer) (int64, error) {
+	h := sha256.New()
+
+	size, err := client.Download(key, io.MultiWriter(sink, h))
+	if err != nil {
+		return size, err
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != hash {
+		return size, &ErrHashMismatch{
+			key:      key,
+			expected: hash,
+			actual:   actual,
+		}
+	}
+
+	return size, nil
+}
+
+// Quarantine appends a record of the mismatched object to the file so it can be
+// investigated later. Each line has the time, key, expected and actual hashes. The
+// object itself is left in place: the mismatch may have come from the download
+// rather than the stored data, and other snapshots share the same object.
+func Quarantine(path string, mismatch *ErrHashMismatch) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s %s %s %s\n", time.Now().Format(time.RFC3339), mismatch.key, mismatch.expected, mismatch.actual)
+	return err
+}
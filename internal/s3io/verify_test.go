@@ -0,0 +1,69 @@
+package s3io_test
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/s3io"
+)
+
+func TestHashFromKey(t *testing.T) {
+	hash := "3c346f7689103d92df482fda90300dd8c66da0a883a9be02ab0eba41d04c39d5"
+
+	require.Equal(t, hash, s3io.HashFromKey("data/3c34/"+hash))
+	require.Equal(t, "", s3io.HashFromKey("data/3c34/"+hash[:10]))
+	require.Equal(t, "", s3io.HashFromKey("manifests/job/label/job-label-2023-05-29-51748.csv.gz"))
+}
+
+// fakeClient serves the objects from memory.
+type fakeClient struct {
+	s3io.Client
+
+	objects map[string]string
+}
+
+func (fc *fakeClient) Download(key string, sink io.Writer) (int64, error) {
+	n, err := io.WriteString(sink, fc.objects[key])
+	return int64(n), err
+}
+
+func TestQuarantine(t *testing.T) {
+	hash := "3c346f7689103d92df482fda90300dd8c66da0a883a9be02ab0eba41d04c39d5"
+	key := "data/3c34/" + hash
+
+	fc := &fakeClient{
+		objects: map[string]string{
+			key: "corrupted",
+		},
+	}
+
+	var sb strings.Builder
+	_, err := s3io.DownloadVerified(fc, key, hash, &sb)
+	var mismatch *s3io.ErrHashMismatch
+	require.ErrorAs(t, err, &mismatch)
+
+	path := filepath.Join(t.TempDir(), "quarantine.txt")
+	require.NoError(t, s3io.Quarantine(path, mismatch))
+
+	// the mismatch is recorded in a file only the user can read
+	st, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), st.Mode().Perm())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	require.True(t, scanner.Scan())
+	fields := strings.Fields(scanner.Text())
+	require.Len(t, fields, 4)
+	require.Equal(t, key, fields[1])
+	require.Equal(t, hash, fields[2])
+}
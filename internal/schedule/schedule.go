@@ -0,0 +1,219 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression with the standard five fields:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field can be '*', a value, a range 'a-b', a step '*/n' or 'a-b/n', or a
+// comma separated list of these. Months and days of the week can also be given
+// by their three letter names. As with cron, if both the day of the month and
+// the day of the week are restricted, a time matches if either of them match.
+type Schedule struct {
+	expr   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	domStar bool
+	dowStar bool
+}
+
+type field struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: []string{
+		"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec",
+	}}
+	dowField = field{name: "day of week", min: 0, max: 7, names: []string{
+		"sun", "mon", "tue", "wed", "thu", "fri", "sat",
+	}}
+)
+
+type ErrBadSchedule struct {
+	msg string
+}
+
+func (e *ErrBadSchedule) Error() string {
+	return e.msg
+}
+
+func Parse(expr string) (*Schedule, error) {
+	tokens := strings.Fields(expr)
+	if len(tokens) != 5 {
+		return nil, &ErrBadSchedule{
+			msg: fmt.Sprintf("schedule must have 5 fields: %s", expr),
+		}
+	}
+
+	sched := Schedule{
+		expr:    expr,
+		domStar: tokens[2] == "*",
+		dowStar: tokens[4] == "*",
+	}
+
+	var err error
+	if sched.minute, err = minuteField.parse(tokens[0]); err != nil {
+		return nil, err
+	}
+	if sched.hour, err = hourField.parse(tokens[1]); err != nil {
+		return nil, err
+	}
+	if sched.dom, err = domField.parse(tokens[2]); err != nil {
+		return nil, err
+	}
+	if sched.month, err = monthField.parse(tokens[3]); err != nil {
+		return nil, err
+	}
+	if sched.dow, err = dowField.parse(tokens[4]); err != nil {
+		return nil, err
+	}
+
+	// sunday is both 0 and 7
+	if sched.dow&(1<<7) != 0 {
+		sched.dow |= 1
+	}
+
+	return &sched, nil
+}
+
+func (sched *Schedule) String() string {
+	return sched.expr
+}
+
+func (f *field) parse(token string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(token, ",") {
+		// split off any step
+		step := 1
+		rng := part
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			rng = part[:idx]
+			s, err := strconv.Atoi(part[idx+1:])
+			if err != nil || s <= 0 {
+				return 0, f.error(token)
+			}
+			step = s
+		}
+
+		// the range of values
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+
+			var err error
+			lo, err = f.value(bounds[0])
+			if err != nil {
+				return 0, f.error(token)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = f.value(bounds[1])
+				if err != nil {
+					return 0, f.error(token)
+				}
+			} else if step > 1 {
+				// 'a/n' means from a to the end of the range
+				hi = f.max
+			}
+		}
+		if lo > hi {
+			return 0, f.error(token)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func (f *field) value(token string) (int, error) {
+	for idx, name := range f.names {
+		if strings.EqualFold(token, name) {
+			return idx + f.min, nil
+		}
+	}
+
+	v, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, err
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("out of range")
+	}
+	return v, nil
+}
+
+func (f *field) error(token string) error {
+	return &ErrBadSchedule{
+		msg: fmt.Sprintf("bad %s in schedule: %s", f.name, token),
+	}
+}
+
+// Matches reports if the schedule fires in the minute containing the time.
+func (sched *Schedule) Matches(t time.Time) bool {
+	return sched.minute&(1<<t.Minute()) != 0 &&
+		sched.hour&(1<<t.Hour()) != 0 &&
+		sched.month&(1<<int(t.Month())) != 0 &&
+		sched.dayMatches(t)
+}
+
+func (sched *Schedule) dayMatches(t time.Time) bool {
+	dom := sched.dom&(1<<t.Day()) != 0
+	dow := sched.dow&(1<<int(t.Weekday())) != 0
+
+	if sched.domStar || sched.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the start of the next minute after the time in which the schedule
+// fires. The zero time is returned if it never fires, e.g. on the 31st of February.
+func (sched *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// give up after five years
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if sched.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !sched.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if sched.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if sched.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,76 @@
+package schedule_test
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/schedule"
+)
+
+func TestParseErrors(t *testing.T) {
+	bad := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"x * * * *",
+	}
+	for _, expr := range bad {
+		_, err := schedule.Parse(expr)
+		require.Error(t, err, expr)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	sched, err := schedule.Parse("30 2 * * mon-fri")
+	require.NoError(t, err)
+
+	// monday 2023-05-01
+	require.True(t, sched.Matches(time.Date(2023, 5, 1, 2, 30, 45, 0, time.UTC)))
+	require.False(t, sched.Matches(time.Date(2023, 5, 1, 2, 31, 0, 0, time.UTC)))
+	// sunday
+	require.False(t, sched.Matches(time.Date(2023, 4, 30, 2, 30, 0, 0, time.UTC)))
+}
+
+func TestStepsAndLists(t *testing.T) {
+	sched, err := schedule.Parse("*/15 8-18/2 * jan,jul *")
+	require.NoError(t, err)
+
+	require.True(t, sched.Matches(time.Date(2023, 1, 10, 8, 45, 0, 0, time.UTC)))
+	require.True(t, sched.Matches(time.Date(2023, 7, 10, 18, 0, 0, 0, time.UTC)))
+	require.False(t, sched.Matches(time.Date(2023, 7, 10, 9, 0, 0, 0, time.UTC)))
+	require.False(t, sched.Matches(time.Date(2023, 2, 10, 8, 0, 0, 0, time.UTC)))
+}
+
+func TestDayOfMonthOrWeek(t *testing.T) {
+	// the 1st of the month or any sunday
+	sched, err := schedule.Parse("0 0 1 * 7")
+	require.NoError(t, err)
+
+	require.True(t, sched.Matches(time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)))
+	require.True(t, sched.Matches(time.Date(2023, 5, 7, 0, 0, 0, 0, time.UTC)))
+	require.False(t, sched.Matches(time.Date(2023, 5, 8, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestNext(t *testing.T) {
+	sched, err := schedule.Parse("15 3 * * *")
+	require.NoError(t, err)
+
+	now := time.Date(2023, 12, 31, 3, 15, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2024, 1, 1, 3, 15, 0, 0, time.UTC), sched.Next(now))
+
+	now = time.Date(2023, 12, 31, 1, 0, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2023, 12, 31, 3, 15, 0, 0, time.UTC), sched.Next(now))
+
+	// never fires
+	sched, err = schedule.Parse("0 0 31 2 *")
+	require.NoError(t, err)
+	require.True(t, sched.Next(now).IsZero())
+}
@@ -0,0 +1,38 @@
+// Package watch collects the paths that change under a directory tree so they can
+// be backed up without rescanning the whole tree.
+package watch
+
+import (
+	"sort"
+)
+
+// SkipFunc reports if the directory with the name, found in a directory at the
+// level below the root, should not be watched.
+type SkipFunc func(name string, level int) bool
+
+type changeSet struct {
+	paths    map[string]bool
+	overflow bool
+}
+
+func (cs *changeSet) add(path string) {
+	if cs.paths == nil {
+		cs.paths = make(map[string]bool)
+	}
+	cs.paths[path] = true
+}
+
+// drain returns the changed paths in order and resets the set.
+func (cs *changeSet) drain() ([]string, bool) {
+	paths := make([]string, 0, len(cs.paths))
+	for path := range cs.paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	overflow := cs.overflow
+	cs.paths = nil
+	cs.overflow = false
+
+	return paths, overflow
+}
@@ -0,0 +1,185 @@
+//go:build linux
+
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_ATTRIB |
+	unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ONLYDIR
+
+// Watcher uses inotify to record the paths, relative to the root, of the files
+// and directories that change under the tree. New directories are watched as
+// they appear. If events are lost, the watcher reports an overflow and the whole
+// tree should be rescanned.
+type Watcher struct {
+	root string
+	skip SkipFunc
+	fd   int
+	file *os.File
+
+	mu      sync.Mutex
+	dirs    map[int]string
+	changes changeSet
+}
+
+func New(root string, skip SkipFunc) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise inotify: %w", err)
+	}
+
+	w := Watcher{
+		root: root,
+		skip: skip,
+		fd:   fd,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int]string),
+	}
+
+	w.mu.Lock()
+	err = w.addTree("")
+	w.mu.Unlock()
+	if err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.run()
+
+	return &w, nil
+}
+
+// Drain returns the paths that have changed since the last call, and whether
+// any events have been lost.
+func (w *Watcher) Drain() ([]string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.changes.drain()
+}
+
+func (w *Watcher) Close() error {
+	return w.file.Close()
+}
+
+// addTree watches the directory and all its subdirectories. Must be called with
+// the lock held.
+func (w *Watcher) addTree(dir string) error {
+	wd, err := unix.InotifyAddWatch(w.fd, filepath.Join(w.root, dir), watchMask)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", filepath.Join(w.root, dir), err)
+	}
+	w.dirs[wd] = dir
+
+	entries, err := os.ReadDir(filepath.Join(w.root, dir))
+	if err != nil {
+		return err
+	}
+
+	level := 0
+	if dir != "" {
+		level = strings.Count(dir, string(os.PathSeparator)) + 1
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() == false {
+			continue
+		}
+		if w.skip != nil && w.skip(entry.Name(), level) {
+			continue
+		}
+		err := w.addTree(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeTree stops watching the directory and its subdirectories. Must be called
+// with the lock held.
+func (w *Watcher) removeTree(dir string) {
+	for wd, path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+func (w *Watcher) run() {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		w.mu.Lock()
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			name := strings.TrimRight(string(nameBytes), "\x00")
+
+			w.process(int(event.Wd), event.Mask, name)
+
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// process handles a single event. Must be called with the lock held.
+func (w *Watcher) process(wd int, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		w.changes.overflow = true
+		return
+	}
+
+	dir, ok := w.dirs[wd]
+	if !ok {
+		return
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+		return
+	}
+	if name == "" {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	w.changes.add(path)
+
+	if mask&unix.IN_ISDIR == 0 {
+		return
+	}
+
+	// keep the watched directories in step with the tree
+	if mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0 {
+		w.removeTree(path)
+	}
+	if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		level := strings.Count(path, string(os.PathSeparator))
+		if w.skip != nil && w.skip(name, level) {
+			return
+		}
+		err := w.addTree(path)
+		if err != nil && errors.Is(err, os.ErrNotExist) == false {
+			// can't track the tree any more
+			w.changes.overflow = true
+		}
+	}
+}
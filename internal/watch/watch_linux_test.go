@@ -0,0 +1,66 @@
+//go:build linux
+
+package watch_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"testing"
+
+	"github.com/studio1767/s3backup/internal/watch"
+)
+
+// drainUntil waits for the watcher to report the expected paths.
+func drainUntil(t *testing.T, w *watch.Watcher, expected []string) map[string]bool {
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		paths, overflow := w.Drain()
+		require.False(t, overflow)
+		for _, path := range paths {
+			seen[path] = true
+		}
+
+		found := true
+		for _, path := range expected {
+			found = found && seen[path]
+		}
+		if found {
+			return seen
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("expected changes not seen: %v, have %v", expected, seen)
+	return nil
+}
+
+func TestWatcherRecordsChanges(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "skip"), 0755))
+
+	skip := func(name string, level int) bool {
+		return name == "skip"
+	}
+
+	w, err := watch.New(root, skip)
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b", "f.txt"), []byte("f"), 0644))
+	drainUntil(t, w, []string{"a/b/f.txt"})
+
+	// new directories are watched
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "c"), 0755))
+	drainUntil(t, w, []string{"c"})
+	require.NoError(t, os.WriteFile(filepath.Join(root, "c", "g.txt"), []byte("g"), 0644))
+	drainUntil(t, w, []string{"c/g.txt"})
+
+	// skipped directories aren't
+	require.NoError(t, os.WriteFile(filepath.Join(root, "skip", "h.txt"), []byte("h"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(root, "a", "b", "f.txt")))
+	seen := drainUntil(t, w, []string{"a/b/f.txt"})
+	require.False(t, seen["skip/h.txt"])
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package watch
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Watcher is only supported on linux.
+type Watcher struct{}
+
+func New(root string, skip SkipFunc) (*Watcher, error) {
+	return nil, fmt.Errorf("watching is not supported on %s", runtime.GOOS)
+}
+
+func (w *Watcher) Drain() ([]string, bool) {
+	return nil, true
+}
+
+func (w *Watcher) Close() error {
+	return nil
+}